@@ -0,0 +1,60 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/flowci/flow-agent-x/util"
+)
+
+// ArchiveCache archive cache paths under workspace to dir in zip, tar.gz or tar.zst format,
+// the archive is named by encoded relative path of the cache
+func ArchiveCache(workspace string, paths []string, dir, format string) []string {
+	var archives []string
+	for _, path := range paths {
+		if !util.IsFileExists(path) {
+			util.LogWarn("the file %s not exist", path)
+			continue
+		}
+
+		if !strings.HasPrefix(path, workspace) {
+			util.LogWarn("the cache path must be under workspace")
+			continue
+		}
+
+		archive := filepath.Join(dir, encodeCacheName(workspace, path))
+
+		var err error
+		if util.IsTarFormat(format) {
+			err = util.TarArchive(path, archive, format)
+		} else {
+			err = util.Zip(path, archive, util.UnixPathSeparator)
+		}
+
+		if err != nil {
+			util.LogWarn(err.Error())
+			continue
+		}
+
+		archives = append(archives, archive)
+	}
+
+	return archives
+}
+
+// ExtractCache extract the archive of cache file to its relative path in workspace, the format is detected by content.
+// The zip archive contains files of the cache dir, and the tar archive contains the cache path as root
+func ExtractCache(archive, workspace, file string) error {
+	dest := workspace + util.UnixPathSeparator + decodeCacheName(file)
+
+	format, err := util.DetectArchiveFormat(archive)
+	if err != nil {
+		return err
+	}
+
+	if util.IsTarFormat(format) {
+		dest = filepath.Dir(dest)
+	}
+
+	return util.ExtractArchive(archive, dest)
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldArchiveAndExtractCache(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, err := ioutil.TempDir("", "test_cache_ws_")
+	assert.NoError(err)
+	defer os.RemoveAll(ws)
+
+	assert.NoError(os.MkdirAll(filepath.Join(ws, "a", "deps"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(ws, "a", "deps", "lib.jar"), []byte("jar"), 0644))
+
+	for _, format := range []string{util.ArchiveFormatZip, util.ArchiveFormatTarGzip, util.ArchiveFormatTarZstd} {
+		dir, err := ioutil.TempDir("", "test_cache_archives_")
+		assert.NoError(err)
+		defer os.RemoveAll(dir)
+
+		archives := ArchiveCache(ws, []string{filepath.Join(ws, "a", "deps")}, dir, format)
+		assert.Len(archives, 1)
+
+		out := filepath.Join(dir, "out")
+		assert.NoError(ExtractCache(archives[0], out, filepath.Base(archives[0])))
+
+		content, err := ioutil.ReadFile(filepath.Join(out, "a", "deps", "lib.jar"))
+		assert.NoError(err, format)
+		assert.Equal("jar", string(content))
+	}
+}
@@ -12,7 +12,6 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync/atomic"
 	"time"
 
@@ -48,9 +47,11 @@ type (
 		SendShellLog(jobId, stepId, b64Log string)
 		SendTtyLog(ttyId, b64Log string)
 
-		CachePut(jobId, name, workspace string, paths []string) error
+		CacheFormats() []string
+		CachePut(jobId, name, format string, archives []string) (*domain.JobCache, error)
 		CacheGet(jobId, name string) *domain.JobCache
-		CacheDownload(cacheId, workspace, file string, progress io.Writer)
+		CacheFind(jobId, prefix string) *domain.JobCache
+		CacheDownload(cacheId, file, dest string, progress io.Writer) error
 
 		GetSecret(name string) (domain.Secret, error)
 		GetConfig(name string) (domain.Config, error)
@@ -185,53 +186,57 @@ func (c *client) SendTtyLog(ttyId, b64Log string) {
 	_ = c.sendMessageWithJson(eventTtyLog, body)
 }
 
-func (c *client) CachePut(jobId, key, workspace string, paths []string) (out error) {
-	defer util.RecoverPanic(func(e error) {
-		out = e
-	})
+// CacheFormats get archive formats supported by server, zip only if the server doesn't support negotiation
+func (c *client) CacheFormats() []string {
+	legacy := []string{util.ArchiveFormatZip}
 
-	tempDir, err := ioutil.TempDir("", "agent_cache_")
-	util.PanicIfErr(err)
-	defer os.RemoveAll(tempDir)
+	raw, err := c.send("GET", "cache/formats", "", nil)
+	if err != nil {
+		return legacy
+	}
 
-	var parts []*part
-	for _, path := range paths {
-		if !util.IsFileExists(path) {
-			util.LogWarn("the file %s not exist", path)
-			continue
-		}
+	resp, err := c.parseResponse(raw, &domain.CacheFormatsResponse{})
+	if err != nil {
+		return legacy
+	}
 
-		if !strings.HasPrefix(path, workspace) {
-			util.LogWarn("the cache path must be under workspace")
-			continue
-		}
+	formats := resp.(*domain.CacheFormatsResponse).Data
+	if len(formats) == 0 {
+		return legacy
+	}
 
-		cacheZipName := encodeCacheName(workspace, path)
-		zipPath := tempDir + util.UnixPathSeparator + cacheZipName
-		err = util.Zip(path, zipPath, util.UnixPathSeparator)
+	return formats
+}
 
-		if err != nil {
-			util.LogWarn(err.Error())
-			continue
-		}
+// CachePut upload the cache archives in format, the uploaded cache is returned if it's provided by server
+func (c *client) CachePut(jobId, key, format string, archives []string) (cache *domain.JobCache, out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = e
+	})
 
-		parts = append(parts, &part{
+	parts := make([]*part, len(archives))
+	for i, archive := range archives {
+		parts[i] = &part{
 			key:  "files",
-			file: zipPath,
-		})
+			file: archive,
+		}
 	}
 
 	buffer, contentType := c.buildMultipartContent(parts)
 
 	path := fmt.Sprintf("cache/%s/%s/%s", jobId, key, util.OS())
+	if format != util.ArchiveFormatZip {
+		path += "?format=" + url.QueryEscape(format)
+	}
+
 	raw, err := c.send("POST", path, contentType, buffer)
 	util.PanicIfErr(err)
 
-	_, err = c.parseResponse(raw, &domain.Response{})
+	resp, err := c.parseResponse(raw, &domain.JobCacheResponse{})
 	util.PanicIfErr(err)
 
-	util.LogInfo("[CachePut] %d/%d files cached in %s", len(parts), len(paths), key)
-	return
+	util.LogInfo("[CachePut] %d files cached in %s", len(parts), key)
+	return resp.(*domain.JobCacheResponse).Data, nil
 }
 
 func (c *client) CacheGet(jobId, key string) *domain.JobCache {
@@ -245,29 +250,36 @@ func (c *client) CacheGet(jobId, key string) *domain.JobCache {
 	return jobCache.Data
 }
 
-func (c *client) CacheDownload(cacheId, workspace, file string, progress io.Writer) {
+// CacheFind get the latest cache which key starts with prefix,
+// the server without prefix matching will find cache by the exact key
+func (c *client) CacheFind(jobId, prefix string) *domain.JobCache {
+	raw, err := c.send("GET", fmt.Sprintf("cache/%s/%s?prefix=true", jobId, url.PathEscape(prefix)), "", nil)
+	util.PanicIfErr(err)
+
+	resp, err := c.parseResponse(raw, &domain.JobCacheResponse{})
+	util.PanicIfErr(err)
+
+	return resp.(*domain.JobCacheResponse).Data
+}
+
+// CacheDownload download the archive of cache file to dest
+func (c *client) CacheDownload(cacheId, file, dest string, progress io.Writer) (out error) {
 	defer util.RecoverPanic(func(e error) {
-		util.LogWarn(e.Error())
+		out = e
 	})
 
-	tmpPath := fmt.Sprintf("%s/%s.tmp", workspace, file)
+	tmpPath := dest + ".tmp"
 	tmpFile, err := os.Create(tmpPath)
 	util.PanicIfErr(err)
+	defer os.Remove(tmpPath)
 
 	err = c.download(fmt.Sprintf("cache/%s?file=%s", cacheId, file), tmpFile, progress)
+	_ = tmpFile.Close()
 	util.PanicIfErr(err)
 
-	zippedFile := workspace + util.UnixPathSeparator + file
-	err = os.Rename(tmpPath, zippedFile)
-	util.PanicIfErr(err)
-
-	defer os.RemoveAll(zippedFile)
-
-	cacheFileName := decodeCacheName(file)
-	dest := workspace + util.UnixPathSeparator + cacheFileName
-
-	err = util.Unzip(zippedFile, dest)
+	err = os.Rename(tmpPath, dest)
 	util.PanicIfErr(err)
+	return
 }
 
 func (c *client) GetSecret(name string) (secret domain.Secret, err error) {
@@ -372,25 +384,37 @@ func (c *client) Close() {
 	}
 }
 
-func (c *client) buildMultipartContent(parts []*part) (*bytes.Buffer, string) {
-	buffer := &bytes.Buffer{}
-	writer := multipart.NewWriter(buffer)
-	defer writer.Close()
+// buildMultipartContent stream files as multipart content through pipe, so the files are not loaded into memory
+func (c *client) buildMultipartContent(parts []*part) (io.Reader, string) {
+	reader, pipe := io.Pipe()
+	writer := multipart.NewWriter(pipe)
 
+	go func() {
+		_ = pipe.CloseWithError(writeMultipartContent(writer, parts))
+	}()
+
+	return reader, writer.FormDataContentType()
+}
+
+func writeMultipartContent(writer *multipart.Writer, parts []*part) error {
 	for _, part := range parts {
 		file, err := os.Open(part.file)
-		util.PanicIfErr(err)
-
-		part, err := writer.CreateFormFile(part.key, filepath.Base(part.file))
-		util.PanicIfErr(err)
+		if err != nil {
+			return err
+		}
 
-		_, err = io.Copy(part, file)
-		util.PanicIfErr(err)
+		w, err := writer.CreateFormFile(part.key, filepath.Base(part.file))
+		if err == nil {
+			_, err = io.Copy(w, file)
+		}
 
 		_ = file.Close()
+		if err != nil {
+			return err
+		}
 	}
 
-	return buffer, writer.FormDataContentType()
+	return writer.Close()
 }
 
 func (c *client) setConnState(state int32) {
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/flowci/flow-agent-x/util"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -16,14 +17,19 @@ func TestShouldCacheFile(t *testing.T) {
 	workspace := "/ws"
 	cacheName := "test_cache"
 
-	c.CachePut(jobId, cacheName, workspace, []string{
+	archives := ArchiveCache(workspace, []string{
 		"/Users/yang/Desktop/cache_1/test",
 		"/Users/yang/Desktop/cache_2",
-	})
+	}, "/ws/archives", util.ArchiveFormatTarZstd)
+
+	_, err := c.CachePut(jobId, cacheName, util.ArchiveFormatTarZstd, archives)
+	assert.NoError(err)
 
 	jobCache := c.CacheGet(jobId, cacheName)
 	assert.NotNil(jobCache)
 
-	c.CacheDownload(jobCache.Id, "/ws/out", "Y2FjaGVfMg==", nil)
-	c.CacheDownload(jobCache.Id, "/ws/out", "Y2FjaGVfMS90ZXN0", nil)
+	for _, file := range []string{"Y2FjaGVfMg==", "Y2FjaGVfMS90ZXN0"} {
+		assert.NoError(c.CacheDownload(jobCache.Id, file, "/ws/archives/"+file, nil))
+		assert.NoError(ExtractCache("/ws/archives/"+file, "/ws/out", file))
+	}
 }
@@ -5,10 +5,15 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"text/tabwriter"
+	"time"
 
+	"github.com/dustin/go-humanize"
 	"github.com/flowci/flow-agent-x/config"
 	"github.com/flowci/flow-agent-x/controller"
 	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/executor"
+	"github.com/flowci/flow-agent-x/service"
 	"github.com/flowci/flow-agent-x/util"
 	"github.com/gin-contrib/pprof"
 	"github.com/gin-gonic/gin"
@@ -85,6 +90,20 @@ func main() {
 			Destination: &cm.K8sCluster,
 		},
 
+		cli.BoolFlag{
+			Name:        "k8sPodExecutor",
+			Usage:       "Run docker steps as k8s pods via k8s api instead of docker daemon",
+			EnvVar:      domain.VarK8sPodExecutor,
+			Destination: &cm.K8sPodExecutor,
+		},
+
+		cli.StringFlag{
+			Name:        "k8sWorkspaceClaim",
+			Usage:       "PVC name of agent workspace, it will be mounted to step pods at the same path",
+			EnvVar:      domain.VarK8sWorkspaceClaim,
+			Destination: &cm.K8sWorkspaceClaim,
+		},
+
 		cli.StringFlag{
 			Name:        "workspace, w",
 			Value:       filepath.Join(util.HomeDir, ".flow.ci.agent"),
@@ -100,6 +119,140 @@ func main() {
 			EnvVar:      domain.VarAgentVolumes,
 			Destination: &cm.VolumesStr,
 		},
+
+		cli.Float64Flag{
+			Name:        "stepCpuLimit",
+			Usage:       "Default number of cpu cores for each step run by shell executor on linux, 0 for unlimited",
+			EnvVar:      domain.VarAgentStepCpuLimit,
+			Destination: &cm.StepCpuLimit,
+		},
+
+		cli.Int64Flag{
+			Name:        "stepMemoryLimit",
+			Usage:       "Default memory limit in MB for each step run by shell executor on linux, 0 for unlimited",
+			EnvVar:      domain.VarAgentStepMemoryLimit,
+			Destination: &cm.StepMemoryLimit,
+		},
+
+		cli.Int64Flag{
+			Name:        "stepPidsLimit",
+			Usage:       "Default max number of processes for each step run by shell executor on linux, 0 for unlimited",
+			EnvVar:      domain.VarAgentStepPidsLimit,
+			Destination: &cm.StepPidsLimit,
+		},
+
+		cli.StringFlag{
+			Name:        "cgroupParent",
+			Value:       "flow-ci-agent",
+			Usage:       "Parent cgroup v2 (relative to /sys/fs/cgroup) for step resource limits",
+			EnvVar:      domain.VarAgentCgroupParent,
+			Destination: &cm.CgroupParent,
+		},
+
+		cli.BoolFlag{
+			Name:        "wsCleanBefore",
+			Usage:       "Clean the flow dir in workspace before the job started",
+			EnvVar:      domain.VarAgentWsCleanBefore,
+			Destination: &cm.WsCleanBefore,
+		},
+
+		cli.BoolFlag{
+			Name:        "wsCleanAfter",
+			Usage:       "Clean the flow dir of previous job in workspace when the next job arrived",
+			EnvVar:      domain.VarAgentWsCleanAfter,
+			Destination: &cm.WsCleanAfter,
+		},
+
+		cli.IntFlag{
+			Name:        "wsKeepFlows",
+			Usage:       "Keep the N most recently used flow dirs in workspace, 0 for unlimited",
+			EnvVar:      domain.VarAgentWsKeepFlows,
+			Destination: &cm.WsKeepFlows,
+		},
+
+		cli.Int64Flag{
+			Name:        "wsMinFreeDisk",
+			Usage:       "Evict least recently used flow dirs if free disk (MB) of workspace under it, 0 for disabled",
+			EnvVar:      domain.VarAgentWsMinFreeDisk,
+			Destination: &cm.WsMinFreeDisk,
+		},
+
+		cli.StringFlag{
+			Name:        "jobDirMode",
+			Value:       domain.JobDirModeFlow,
+			Usage:       "Job dir in workspace, 'flow' shared by jobs of flow, 'job' for each job, 'job-copy' or 'job-link' to reuse last successful job dir",
+			EnvVar:      domain.VarAgentJobDirMode,
+			Destination: &cm.JobDirMode,
+		},
+
+		cli.StringFlag{
+			Name:        "pluginMirror",
+			Usage:       "Local dir of plugin archives with .sha256 files or git repos, used if plugin server is unreachable",
+			EnvVar:      domain.VarAgentPluginMirror,
+			Destination: &cm.PluginMirror,
+		},
+
+		cli.IntFlag{
+			Name:        "pluginGcDays",
+			Usage:       "Remove plugins unused for N days, 0 for disabled",
+			EnvVar:      domain.VarAgentPluginGcDays,
+			Destination: &cm.PluginGcDays,
+		},
+
+		cli.Int64Flag{
+			Name:        "cacheMaxSize",
+			Usage:       "Max size (MB) of local cache store in workspace, least recently used caches are evicted, 0 for disabled",
+			EnvVar:      domain.VarAgentCacheMaxSize,
+			Destination: &cm.CacheMaxSize,
+		},
+
+		cli.StringFlag{
+			Name:        "dockerHost",
+			Usage:       "Docker endpoint, unix:///path, tcp://host:port, 'rootless' or 'podman', detect from $DOCKER_HOST and sockets if empty",
+			EnvVar:      domain.VarAgentDockerHost,
+			Destination: &cm.DockerHost,
+		},
+
+		cli.BoolFlag{
+			Name:        "dockerTlsVerify",
+			Usage:       "Verify the docker daemon certificate",
+			EnvVar:      domain.VarAgentDockerTlsVerify,
+			Destination: &cm.DockerTlsVerify,
+		},
+
+		cli.StringFlag{
+			Name:        "dockerCertPath",
+			Usage:       "Dir of ca.pem, cert.pem and key.pem to connect docker daemon with tls",
+			EnvVar:      domain.VarAgentDockerCertPath,
+			Destination: &cm.DockerCertPath,
+		},
+
+		cli.StringFlag{
+			Name:        "dockerApiVersion",
+			Usage:       "Fixed docker api version, negotiate with daemon if empty",
+			EnvVar:      domain.VarAgentDockerApiVersion,
+			Destination: &cm.DockerApiVersion,
+		},
+	}
+
+	app.Commands = []cli.Command{
+		{
+			Name:  "plugins",
+			Usage: "Inspect or remove plugins in the workspace without connecting to server",
+			Subcommands: []cli.Command{
+				{
+					Name:   "list",
+					Usage:  "List plugins with version, commit, size and last used time",
+					Action: listPlugins,
+				},
+				{
+					Name:      "rm",
+					Usage:     "Remove all versions of plugin by name, or single version by 'name@version'",
+					ArgsUsage: "<name>[@version] ...",
+					Action:    removePlugins,
+				},
+			},
+		},
 	}
 
 	err := app.Run(os.Args)
@@ -120,16 +273,31 @@ func start(c *cli.Context) error {
 
 	defer cm.Close()
 
+	checkDocker(cm)
+
 	// connect to ci server
 	startGin(cm)
 
 	return nil
 }
 
+// checkDocker verify docker daemon is reachable and api version is compatible
+func checkDocker(cm *config.Manager) {
+	dockerCli, err := executor.NewDockerClient(cm.AppCtx, cm.Docker())
+	if err != nil {
+		util.LogWarn("Docker is not available, the step with docker option will be failed: %s", err.Error())
+		return
+	}
+
+	util.LogInfo("Docker connected, api version %s", dockerCli.ClientVersion())
+	_ = dockerCli.Close()
+}
+
 func startGin(cm *config.Manager) {
 	router := gin.Default()
 	controller.NewCmdController(router)
 	controller.NewHealthController(router)
+	controller.NewPluginController(router)
 
 	if cm.Debug {
 		pprof.Register(router)
@@ -154,3 +322,45 @@ func startGin(cm *config.Manager) {
 		util.FailOnError(err, "Unable to stop the agent")
 	}
 }
+
+func listPlugins(c *cli.Context) error {
+	cm := config.GetInstance()
+	manager := service.NewPluginManager(config.GetPluginDir(cm.Workspace), cm.Server, "", 0)
+
+	plugins, err := manager.List()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tVERSION\tCOMMIT\tSIZE\tLAST USED")
+
+	for _, p := range plugins {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n",
+			p.Name, p.Version, p.Commit, humanize.Bytes(uint64(p.Size)), p.LastUsed.Format(time.RFC3339))
+	}
+
+	return w.Flush()
+}
+
+func removePlugins(c *cli.Context) error {
+	if c.NArg() == 0 {
+		return fmt.Errorf("plugin name is required")
+	}
+
+	cm := config.GetInstance()
+	manager := service.NewPluginManager(config.GetPluginDir(cm.Workspace), cm.Server, "", 0)
+
+	for _, name := range c.Args() {
+		removed, err := manager.Remove(name)
+		for _, p := range removed {
+			fmt.Printf("Plugin %s removed\n", p.Dir)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err.Error())
+		}
+	}
+
+	return nil
+}
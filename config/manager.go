@@ -20,6 +20,7 @@ import (
 
 const pluginDir = ".plugins"
 const logDir = ".logs"
+const cacheDir = ".caches"
 
 type (
 	// Manager to handle server connection and config
@@ -41,9 +42,16 @@ type (
 		K8sPodIp     string
 		K8sNamespace string
 
+		K8sPodExecutor    bool
+		K8sWorkspaceClaim string
+
 		Workspace    string
 		LoggingDir   string
 		PluginDir    string
+		PluginMirror string
+		PluginGcDays int
+		CacheDir     string
+		CacheMaxSize int64
 		IsFromDocker bool
 
 		Client api.Client
@@ -51,6 +59,22 @@ type (
 		VolumesStr string
 		Volumes    []*domain.DockerVolume
 
+		StepCpuLimit    float64
+		StepMemoryLimit int64
+		StepPidsLimit   int64
+		CgroupParent    string
+
+		WsCleanBefore bool
+		WsCleanAfter  bool
+		WsKeepFlows   int
+		WsMinFreeDisk int64
+		JobDirMode    string
+
+		DockerHost       string
+		DockerTlsVerify  bool
+		DockerCertPath   string
+		DockerApiVersion string
+
 		AppCtx context.Context
 		Cancel context.CancelFunc
 
@@ -67,14 +91,20 @@ func (m *Manager) Init() {
 		m.Port = m.getDefaultPort()
 	}
 	var err error
+	if util.IsEmptyString(m.JobDirMode) {
+		m.JobDirMode = domain.JobDirModeFlow
+	}
+	util.PanicIfErr(domain.ValidateJobDirMode(m.JobDirMode))
+
 	m.ProfileEnabled, err = strconv.ParseBool(m.ProfileEnabledStr)
 	util.PanicIfErr(err)
 
 	m.IsFromDocker, err = strconv.ParseBool(util.GetEnv(domain.VarAgentFromDocker, "false"))
 	util.PanicIfErr(err)
 
-	m.PluginDir = filepath.Join(m.Workspace, pluginDir)
+	m.PluginDir = GetPluginDir(m.Workspace)
 	m.LoggingDir = filepath.Join(m.Workspace, logDir)
+	m.CacheDir = filepath.Join(m.Workspace, cacheDir)
 
 	m.K8sNodeName = os.Getenv(domain.VarK8sNodeName)
 	m.K8sPodName = os.Getenv(domain.VarK8sPodName)
@@ -126,6 +156,38 @@ func (m *Manager) FetchProfile() *domain.AgentProfile {
 	}
 }
 
+// GetPluginDir get plugin dir under the workspace
+func GetPluginDir(workspace string) string {
+	return filepath.Join(workspace, pluginDir)
+}
+
+// StepResources default resource limits for each step
+func (m *Manager) StepResources() *domain.ResourceLimit {
+	return &domain.ResourceLimit{
+		Cpu:    m.StepCpuLimit,
+		Memory: m.StepMemoryLimit,
+		Pids:   m.StepPidsLimit,
+	}
+}
+
+func (m *Manager) WorkspacePolicy() *domain.WorkspacePolicy {
+	return &domain.WorkspacePolicy{
+		CleanBefore: m.WsCleanBefore,
+		CleanAfter:  m.WsCleanAfter,
+		KeepFlows:   m.WsKeepFlows,
+		MinFreeDisk: m.WsMinFreeDisk,
+	}
+}
+
+func (m *Manager) Docker() *domain.DockerHost {
+	return &domain.DockerHost{
+		Host:       m.DockerHost,
+		TLSVerify:  m.DockerTlsVerify,
+		CertPath:   m.DockerCertPath,
+		APIVersion: m.DockerApiVersion,
+	}
+}
+
 func (m *Manager) FireEvent(event domain.AppEvent) {
 	if f, ok := m.events[event]; ok {
 		f()
@@ -181,9 +243,16 @@ func (m *Manager) printInfo() {
 	util.LogInfo("--- [Port]: %d", m.Port)
 	util.LogInfo("--- [Workspace]: %s", m.Workspace)
 	util.LogInfo("--- [Plugin Dir]: %s", m.PluginDir)
+	util.LogInfo("--- [Plugin Mirror]: %s", m.PluginMirror)
+	util.LogInfo("--- [Plugin GC]: %d (days)", m.PluginGcDays)
 	util.LogInfo("--- [Log Dir]: %s", m.LoggingDir)
+	util.LogInfo("--- [Cache Dir]: %s (max %dMB)", m.CacheDir, m.CacheMaxSize)
 	util.LogInfo("--- [Volume Str]: %s", m.VolumesStr)
 	util.LogInfo("--- [Exit On Idle]: %d (seconds)", m.config.ExitOnIdle)
+	util.LogInfo("--- [Step Resources]: %s", m.StepResources())
+	util.LogInfo("--- [Workspace Policy]: %s", m.WorkspacePolicy())
+	util.LogInfo("--- [Job Dir Mode]: %s", m.JobDirMode)
+	util.LogInfo("--- [Docker Host]: %s", m.Docker())
 
 	if m.K8sEnabled {
 		util.LogInfo("--- [K8s InCluster]: %d", m.K8sCluster)
@@ -191,6 +260,8 @@ func (m *Manager) printInfo() {
 		util.LogInfo("--- [K8s Namespace]: %s", m.K8sNamespace)
 		util.LogInfo("--- [K8s Pod]: %s", m.K8sPodName)
 		util.LogInfo("--- [K8s Pod IP]: %s", m.K8sPodIp)
+		util.LogInfo("--- [K8s Pod Executor]: %t", m.K8sPodExecutor)
+		util.LogInfo("--- [K8s Workspace Claim]: %s", m.K8sWorkspaceClaim)
 	}
 }
 
@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"github.com/flowci/flow-agent-x/service"
+	"github.com/gin-gonic/gin"
+)
+
+type PluginController struct {
+	RootController `path:"/plugins"`
+
+	GetPlugins gin.HandlerFunc `path:"/"`
+
+	DeletePlugin gin.HandlerFunc `path:"/:name"`
+
+	cmdService *service.CmdService
+}
+
+// NewPluginController create new instance of PluginController
+func NewPluginController(router *gin.Engine) *PluginController {
+	c := new(PluginController)
+	c.cmdService = service.GetCmdService()
+
+	autoWireController(c, router)
+	return c
+}
+
+// GetPluginsImpl http get to list plugins with version, commit, size and last used time
+func (c *PluginController) GetPluginsImpl(context *gin.Context) {
+	plugins, err := c.cmdService.ListPlugins()
+	if c.responseIfError(context, err) {
+		return
+	}
+
+	c.responseOk(context, plugins)
+}
+
+// DeletePluginImpl http delete to remove all versions of plugin by name, or single version by 'name@version'
+func (c *PluginController) DeletePluginImpl(context *gin.Context) {
+	removed, err := c.cmdService.RemovePlugins(context.Param("name"))
+	if c.responseIfError(context, err) {
+		return
+	}
+
+	c.responseOk(context, removed)
+}
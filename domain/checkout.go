@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+type (
+	// Checkout the git repo that checkout to job dir by agent before running the script
+	Checkout struct {
+		Url        string `json:"url"`
+		Ref        string `json:"ref"`        // branch or tag, default branch of remote if empty
+		Commit     string `json:"commit"`     // commit to checkout, it should be reachable from ref within depth
+		Depth      int    `json:"depth"`      // shallow clone depth, 0 for full history
+		Submodules bool   `json:"submodules"` // update submodules recursively
+		Lfs        bool   `json:"lfs"`        // pull lfs files, requires git-lfs on agent
+		Secret     string `json:"secret"`     // name of auth secret for http or ssh rsa secret for ssh
+
+		// skip ssh host key verification if known_hosts is not available on agent, it's not recommended
+		InsecureHostKey bool `json:"insecureHostKey"`
+
+		AuthContent *SimpleAuthPair `json:"-"` // the real auth secret from 'secret' name
+		KeyContent  *SimpleKeyPair  `json:"-"` // the real rsa secret from 'secret' name
+	}
+)
+
+func (c *Checkout) HasSecret() bool {
+	return c.Secret != ""
+}
+
+// IsSSH is ssh url, ex: git@host:path or ssh://host/path
+func (c *Checkout) IsSSH() bool {
+	if strings.HasPrefix(c.Url, "ssh://") {
+		return true
+	}
+	return !strings.Contains(c.Url, "://") && strings.Contains(c.Url, "@")
+}
+
+func (c *Checkout) Validate() error {
+	if c.Url == "" {
+		return fmt.Errorf("checkout: 'url' is required")
+	}
+
+	if c.Depth < 0 {
+		return fmt.Errorf("checkout: invalid 'depth' = %d", c.Depth)
+	}
+
+	return nil
+}
+
+func (c *Checkout) String() string {
+	ref := c.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	if c.Commit != "" {
+		ref += "@" + c.Commit
+	}
+
+	return fmt.Sprintf("%s %s", c.Url, ref)
+}
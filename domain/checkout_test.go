@@ -0,0 +1,38 @@
+package domain
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDetectSSHUrlOfCheckout(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True((&Checkout{Url: "git@github.com:flowci/flow-agent-x.git"}).IsSSH())
+	assert.True((&Checkout{Url: "ssh://git@github.com/flowci/flow-agent-x.git"}).IsSSH())
+	assert.False((&Checkout{Url: "https://user@github.com/flowci/flow-agent-x.git"}).IsSSH())
+	assert.False((&Checkout{Url: "/tmp/repo"}).IsSSH())
+
+	assert.Error((&Checkout{}).Validate())
+	assert.Error((&Checkout{Url: "/tmp/repo", Depth: -1}).Validate())
+	assert.Equal("/tmp/repo main@abc", (&Checkout{Url: "/tmp/repo", Ref: "main", Commit: "abc"}).String())
+}
+
+func TestShouldNotMarshalSecretContentOfCheckout(t *testing.T) {
+	assert := assert.New(t)
+
+	checkout := &Checkout{
+		Url:         "https://github.com/flowci/flow-agent-x.git",
+		Secret:      "my-auth",
+		AuthContent: &SimpleAuthPair{Username: "user", Password: "p4ss"},
+		KeyContent:  &SimpleKeyPair{PrivateKey: "private-key"},
+	}
+
+	raw, err := json.Marshal(checkout)
+	assert.NoError(err)
+	assert.Contains(string(raw), "my-auth")
+	assert.NotContains(string(raw), "p4ss")
+	assert.NotContains(string(raw), "private-key")
+}
@@ -40,6 +40,11 @@ const (
 	CmdExitCodeSuccess = 0
 )
 
+const (
+	// CmdErrorOOMKilled error prefix if process killed by oom killer
+	CmdErrorOOMKilled = "oom killed"
+)
+
 type (
 	CmdIn struct {
 		Type CmdType `json:"type"`
@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"math"
+	"time"
+)
+
+type (
+	// RetryPolicy control how the failed shell cmd will be retried, the number of retry defined in ShellIn.Retry
+	RetryPolicy struct {
+		Delay     int     `json:"delay"`     // seconds to wait before the first retry
+		Backoff   float64 `json:"backoff"`   // multiplier applied on delay for each further retry
+		MaxDelay  int     `json:"maxDelay"`  // max seconds of delay, 0 for unlimited
+		ExitCodes []int   `json:"exitCodes"` // retry only on these exit codes, empty for any non-zero exit code
+		OnTimeout bool    `json:"onTimeout"` // retry if cmd timeout
+	}
+
+	// ShellAttempt result of each attempt of shell cmd
+	ShellAttempt struct {
+		Attempt  int       `json:"attempt"`
+		Status   CmdStatus `json:"status"`
+		Code     int       `json:"code"`
+		Error    string    `json:"error"`
+		StartAt  time.Time `json:"startAt"`
+		FinishAt time.Time `json:"finishAt"`
+		Duration int64     `json:"duration"` // in milliseconds
+	}
+)
+
+// DefaultRetryPolicy retry immediately on any exception
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{}
+}
+
+// DelayOf get delay before the retry, the retry starts from 1
+func (p *RetryPolicy) DelayOf(retry int) time.Duration {
+	if p.Delay <= 0 || retry < 1 {
+		return 0
+	}
+
+	backoff := p.Backoff
+	if backoff < 1 {
+		backoff = 1
+	}
+
+	seconds := float64(p.Delay) * math.Pow(backoff, float64(retry-1))
+	if p.MaxDelay > 0 && seconds > float64(p.MaxDelay) {
+		seconds = float64(p.MaxDelay)
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// ShouldRetry check the result of attempt is retryable,
+// the agent error (err != nil) is always retryable, the killed cmd never be retried
+func (p *RetryPolicy) ShouldRetry(out *ShellOut, err error) bool {
+	if err != nil {
+		return true
+	}
+
+	switch out.Status {
+	case CmdStatusTimeout:
+		return p.OnTimeout
+	case CmdStatusException:
+		return p.isRetryableCode(out.Code)
+	default:
+		return false
+	}
+}
+
+func (p *RetryPolicy) isRetryableCode(code int) bool {
+	if len(p.ExitCodes) == 0 {
+		return true
+	}
+
+	for _, c := range p.ExitCodes {
+		if c == code {
+			return true
+		}
+	}
+
+	return false
+}
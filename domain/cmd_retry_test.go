@@ -0,0 +1,47 @@
+package domain
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldGetDelayOfRetryWithBackoff(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &RetryPolicy{
+		Delay:    2,
+		Backoff:  2,
+		MaxDelay: 10,
+	}
+
+	assert.Equal(time.Duration(0), policy.DelayOf(0))
+	assert.Equal(2*time.Second, policy.DelayOf(1))
+	assert.Equal(4*time.Second, policy.DelayOf(2))
+	assert.Equal(8*time.Second, policy.DelayOf(3))
+	assert.Equal(10*time.Second, policy.DelayOf(4))
+
+	assert.Equal(time.Duration(0), DefaultRetryPolicy().DelayOf(1))
+}
+
+func TestShouldRetryByExitCodesAndTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	policy := &RetryPolicy{
+		ExitCodes: []int{128},
+	}
+
+	assert.True(policy.ShouldRetry(&ShellOut{Status: CmdStatusException, Code: 128}, nil))
+	assert.False(policy.ShouldRetry(&ShellOut{Status: CmdStatusException, Code: 1}, nil))
+	assert.False(policy.ShouldRetry(&ShellOut{Status: CmdStatusTimeout, Code: CmdExitCodeTimeOut}, nil))
+	assert.False(policy.ShouldRetry(&ShellOut{Status: CmdStatusKilled, Code: CmdExitCodeKilled}, nil))
+	assert.False(policy.ShouldRetry(&ShellOut{Status: CmdStatusSuccess, Code: 0}, nil))
+	assert.True(policy.ShouldRetry(&ShellOut{Status: CmdStatusException}, fmt.Errorf("agent error")))
+
+	policy.OnTimeout = true
+	assert.True(policy.ShouldRetry(&ShellOut{Status: CmdStatusTimeout, Code: CmdExitCodeTimeOut}, nil))
+
+	assert.True(DefaultRetryPolicy().ShouldRetry(&ShellOut{Status: CmdStatusException, Code: 1}, nil))
+}
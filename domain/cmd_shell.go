@@ -5,42 +5,61 @@ import (
 	"time"
 )
 
+const (
+	// DefaultKillGracePeriod seconds to wait for the process exit after SIGTERM
+	DefaultKillGracePeriod = 10
+)
+
 type (
 	Cache struct {
-		Key   string   `json:"key"`
-		Paths []string `json:"paths"`
+		Key         string   `json:"key"`
+		RestoreKeys []string `json:"restoreKeys"` // prefix of keys to restore in order if key not found
+		Paths       []string `json:"paths"`
+
+		HitKey     string `json:"-"` // key of restored cache, empty if not found
+		Manifest   string `json:"-"` // manifest of restored cache, used to detect changes on upload
+		Unresolved bool   `json:"-"` // hashFiles in key cannot be resolved, the cache is neither restored nor uploaded
 	}
 
 	ShellIn struct {
 		CmdIn
-		ID           string          `json:"id"`
-		FlowId       string          `json:"flowId"`
-		JobId        string          `json:"jobId"`
-		AllowFailure bool            `json:"allowFailure"`
-		Plugin       string          `json:"plugin"`
-		Cache        *Cache          `json:"cache"`
-		Dockers      []*DockerOption `json:"dockers"`
-		Bash         []string        `json:"bash"`
-		Pwsh         []string        `json:"pwsh"`
-		Retry        int             `json:"retry"`
-		Timeout      int             `json:"timeout"`
-		Inputs       Variables       `json:"inputs"`
-		EnvFilters   []string        `json:"envFilters"`
-		Secrets      []string        `json:"secrets"` // secret name list
-		Configs      []string        `json:"configs"` // config name list
+		ID             string          `json:"id"`
+		FlowId         string          `json:"flowId"`
+		JobId          string          `json:"jobId"`
+		AllowFailure   bool            `json:"allowFailure"`
+		Plugin         string          `json:"plugin"`
+		PluginChecksum string          `json:"pluginChecksum"` // sha256 of plugin files, verified if present
+		PluginArchive  *PluginArchive  `json:"pluginArchive"`  // load plugin from archive url instead of git
+		Checkout       *Checkout       `json:"checkout"`       // git checkout to job dir before the script
+		Cache          *Cache          `json:"cache"`
+		Dockers        []*DockerOption `json:"dockers"`
+		Bash           []string        `json:"bash"`
+		Pwsh           []string        `json:"pwsh"`
+		Retry          int             `json:"retry"`
+		RetryPolicy    *RetryPolicy    `json:"retryPolicy"`
+		Timeout        int             `json:"timeout"`
+		GracePeriod    int             `json:"gracePeriod"` // seconds to wait after SIGTERM before SIGKILL
+		Resources      *ResourceLimit  `json:"resources"`   // limits for shell executor on linux
+		Inputs         Variables       `json:"inputs"`
+		EnvFilters     []string        `json:"envFilters"`
+		Secrets        []string        `json:"secrets"` // secret name list
+		Configs        []string        `json:"configs"` // config name list
 	}
 
 	ShellOut struct {
-		ID         string    `json:"id"`
-		ProcessId  int       `json:"processId"`
-		Containers []string  `json:"containers"` // container ids applied for shell
-		Status     CmdStatus `json:"status"`
-		Code       int       `json:"code"`
-		Output     Variables `json:"output"`
-		StartAt    time.Time `json:"startAt"`
-		FinishAt   time.Time `json:"finishAt"`
-		Error      string    `json:"error"`
-		LogSize    int64     `json:"logSize"`
+		ID           string          `json:"id"`
+		ProcessId    int             `json:"processId"`
+		Containers   []string        `json:"containers"`   // container ids applied for shell
+		ImageDigests []string        `json:"imageDigests"` // resolved image digest for each container
+		PluginCommit string          `json:"pluginCommit"` // resolved commit id of plugin
+		Status       CmdStatus       `json:"status"`
+		Code         int             `json:"code"`
+		Output       Variables       `json:"output"`
+		StartAt      time.Time       `json:"startAt"`
+		FinishAt     time.Time       `json:"finishAt"`
+		Error        string          `json:"error"`
+		LogSize      int64           `json:"logSize"`
+		Attempts     []*ShellAttempt `json:"attempts"`
 	}
 
 	ShellLog struct {
@@ -70,6 +89,24 @@ func (in *ShellIn) HasPlugin() bool {
 	return in.Plugin != ""
 }
 
+func (in *ShellIn) HasCheckout() bool {
+	return in.Checkout != nil
+}
+
+func (in *ShellIn) GetRetryPolicy() *RetryPolicy {
+	if in.RetryPolicy == nil {
+		return DefaultRetryPolicy()
+	}
+	return in.RetryPolicy
+}
+
+func (in *ShellIn) GetGracePeriod() time.Duration {
+	if in.GracePeriod <= 0 {
+		return DefaultKillGracePeriod * time.Second
+	}
+	return time.Duration(in.GracePeriod) * time.Second
+}
+
 func (in *ShellIn) HasDockerOption() bool {
 	return in.Dockers != nil && len(in.Dockers) > 0
 }
@@ -118,6 +155,31 @@ func (e *ShellOut) IsFinishStatus() bool {
 	}
 }
 
+// AddAttempt record current status as result of the attempt
+func (e *ShellOut) AddAttempt(attempt int, startAt time.Time) {
+	finishAt := e.FinishAt
+	if finishAt.Before(startAt) {
+		finishAt = time.Now()
+	}
+
+	e.Attempts = append(e.Attempts, &ShellAttempt{
+		Attempt:  attempt,
+		Status:   e.Status,
+		Code:     e.Code,
+		Error:    e.Error,
+		StartAt:  startAt,
+		FinishAt: finishAt,
+		Duration: finishAt.Sub(startAt).Milliseconds(),
+	})
+}
+
+// ResetForRetry reset status, code and error for the next attempt
+func (e *ShellOut) ResetForRetry() {
+	e.Status = CmdStatusPending
+	e.Code = CmdExitCodeUnknown
+	e.Error = ""
+}
+
 func (e *ShellOut) ToBytes() []byte {
 	data, _ := json.Marshal(e)
 	return append(shellOutInd, data...)
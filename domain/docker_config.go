@@ -3,16 +3,24 @@ package domain
 import "github.com/docker/docker/api/types/container"
 
 type DockerConfig struct {
-	Name     string
-	Auth     *SimpleAuthPair
-	Config   *container.Config
-	Host     *container.HostConfig
-	IsStop   bool
-	IsDelete bool
+	Name       string
+	Auth       *SimpleAuthPair
+	Config     *container.Config
+	Host       *container.HostConfig
+	Platform   string // expected os/arch[/variant] of image
+	PullPolicy string
+	IsStop     bool
+	IsDelete   bool
+
+	HealthCheck *DockerHealthCheck // readiness check for service container
 
 	ContainerID string // try to resume if container id is existed
 }
 
+func (c *DockerConfig) HasHealthCheck() bool {
+	return c.HealthCheck != nil
+}
+
 func (c *DockerConfig) HasEntrypoint() bool {
 	return c.Config.Entrypoint != nil && len(c.Config.Entrypoint) > 0
 }
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	defaultHealthCheckInterval = 2
+	defaultHealthCheckTimeout  = 5
+	defaultHealthCheckRetries  = 10
+)
+
+type (
+	// DockerHealthCheck readiness check of service container before the runtime script starts
+	DockerHealthCheck struct {
+		Command     []string `json:"command"`     // run in the container, executed by shell if only one element
+		Port        int      `json:"port"`        // tcp port checked from runtime container if no command
+		Interval    int      `json:"interval"`    // seconds between checks
+		Timeout     int      `json:"timeout"`     // seconds to wait for each check
+		Retries     int      `json:"retries"`     // consecutive failures to consider the container unhealthy
+		StartPeriod int      `json:"startPeriod"` // seconds of failures not been counted after container started
+	}
+)
+
+func (h *DockerHealthCheck) HasCommand() bool {
+	return len(h.Command) > 0
+}
+
+func (h *DockerHealthCheck) Validate() error {
+	if !h.HasCommand() && h.Port == 0 {
+		return fmt.Errorf("command or port is required")
+	}
+
+	if h.Port < 0 || h.Port > 65535 {
+		return fmt.Errorf("port %d is out of range", h.Port)
+	}
+
+	if h.Interval < 0 || h.Timeout < 0 || h.Retries < 0 || h.StartPeriod < 0 {
+		return fmt.Errorf("interval, timeout, retries and startPeriod must be positive")
+	}
+
+	return nil
+}
+
+func (h *DockerHealthCheck) GetInterval() time.Duration {
+	return secondsOrDefault(h.Interval, defaultHealthCheckInterval)
+}
+
+func (h *DockerHealthCheck) GetTimeout() time.Duration {
+	return secondsOrDefault(h.Timeout, defaultHealthCheckTimeout)
+}
+
+func (h *DockerHealthCheck) GetRetries() int {
+	if h.Retries <= 0 {
+		return defaultHealthCheckRetries
+	}
+	return h.Retries
+}
+
+func (h *DockerHealthCheck) GetStartPeriod() time.Duration {
+	return time.Duration(h.StartPeriod) * time.Second
+}
+
+// ToConfig convert to docker health config, nil if it's port check
+func (h *DockerHealthCheck) ToConfig() *container.HealthConfig {
+	if !h.HasCommand() {
+		return nil
+	}
+
+	test := append([]string{"CMD"}, h.Command...)
+	if len(h.Command) == 1 {
+		test = []string{"CMD-SHELL", h.Command[0]}
+	}
+
+	return &container.HealthConfig{
+		Test:        test,
+		Interval:    h.GetInterval(),
+		Timeout:     h.GetTimeout(),
+		Retries:     h.GetRetries(),
+		StartPeriod: h.GetStartPeriod(),
+	}
+}
+
+func secondsOrDefault(seconds, def int) time.Duration {
+	if seconds <= 0 {
+		seconds = def
+	}
+	return time.Duration(seconds) * time.Second
+}
@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldConvertHealthCheckToDockerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	hc := &DockerHealthCheck{Command: []string{"pg_isready -U postgres"}, Interval: 1}
+	assert.NoError(hc.Validate())
+
+	config := hc.ToConfig()
+	assert.Equal([]string{"CMD-SHELL", "pg_isready -U postgres"}, config.Test)
+	assert.Equal(time.Second, config.Interval)
+	assert.Equal(5*time.Second, config.Timeout)
+	assert.Equal(10, config.Retries)
+
+	hc = &DockerHealthCheck{Command: []string{"mysqladmin", "ping"}}
+	assert.Equal([]string{"CMD", "mysqladmin", "ping"}, hc.ToConfig().Test)
+
+	// port check run by agent
+	hc = &DockerHealthCheck{Port: 3306}
+	assert.NoError(hc.Validate())
+	assert.Nil(hc.ToConfig())
+
+	assert.Error((&DockerHealthCheck{}).Validate())
+	assert.Error((&DockerHealthCheck{Port: 70000}).Validate())
+
+	option := &DockerOption{Image: "mysql:5.6", HealthCheck: &DockerHealthCheck{}}
+	assert.Contains(option.Validate().Error(), "'healthCheck'")
+}
@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/flowci/flow-agent-x/util"
+)
+
+const (
+	DockerHostRootless = "rootless"
+	DockerHostPodman   = "podman"
+
+	DockerSockInContainer = "/var/run/docker.sock"
+
+	// DockerSidecarHost docker in docker sidecar that listens on tcp in the same pod of agent
+	DockerSidecarHost = "tcp://localhost:2375"
+
+	dockerDefaultUnixHost = "unix:///var/run/docker.sock"
+	dockerDefaultWinHost  = "npipe:////./pipe/docker_engine"
+	dockerDefaultTcpPort  = "2375"
+)
+
+type (
+	// DockerHost the docker endpoint that used by agent and exported into steps
+	DockerHost struct {
+		Host       string // unix://, tcp://, npipe://, or 'rootless', 'podman', empty to detect
+		TLSVerify  bool   // $DOCKER_TLS_VERIFY if it's not set
+		CertPath   string // dir contains ca.pem, cert.pem and key.pem, $DOCKER_CERT_PATH if it's empty
+		APIVersion string // fixed api version, empty to negotiate with daemon
+	}
+)
+
+// ResolveHost get docker host url, the order is explicit host, $DOCKER_HOST, then detect from sockets
+func (h *DockerHost) ResolveHost() string {
+	switch h.Host {
+	case DockerHostRootless:
+		return "unix://" + rootlessDockerSock()
+	case DockerHostPodman:
+		return "unix://" + podmanSock()
+	case "":
+		if env := os.Getenv("DOCKER_HOST"); util.HasString(env) {
+			return env
+		}
+		return detectDockerHost()
+	default:
+		return h.Host
+	}
+}
+
+// SocketPath get unix socket path of docker host, empty if it's not unix socket
+func (h *DockerHost) SocketPath() string {
+	host := h.ResolveHost()
+	if !strings.HasPrefix(host, "unix://") {
+		return ""
+	}
+	return strings.TrimPrefix(host, "unix://")
+}
+
+// GetCertPath get cert path from option or $DOCKER_CERT_PATH
+func (h *DockerHost) GetCertPath() string {
+	if util.HasString(h.CertPath) {
+		return h.CertPath
+	}
+	return os.Getenv("DOCKER_CERT_PATH")
+}
+
+// IsTLSVerify verify the daemon cert from option or $DOCKER_TLS_VERIFY
+func (h *DockerHost) IsTLSVerify() bool {
+	return h.TLSVerify || util.HasString(os.Getenv("DOCKER_TLS_VERIFY"))
+}
+
+func (h *DockerHost) IsTLS() bool {
+	return util.HasString(h.GetCertPath()) || h.IsTLSVerify()
+}
+
+// Validate the cert path is required to verify tls
+func (h *DockerHost) Validate() error {
+	if h.IsTLSVerify() && util.IsEmptyString(h.GetCertPath()) {
+		return fmt.Errorf("docker cert path is required if tls verify is enabled")
+	}
+	return nil
+}
+
+// HostForContainer get DOCKER_HOST for the containers started by agent,
+// the unix socket will be mounted to DockerSockInContainer, and loopback address will be replaced by agentIp
+func (h *DockerHost) HostForContainer(agentIp string) (string, error) {
+	host := h.ResolveHost()
+	if strings.HasPrefix(host, "unix://") || strings.HasPrefix(host, "npipe://") {
+		return "unix://" + DockerSockInContainer, nil
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid docker host %s: %s", host, err.Error())
+	}
+
+	hostname := u.Hostname()
+	if util.HasString(agentIp) && (hostname == "localhost" || hostname == "127.0.0.1" || hostname == "") {
+		port := u.Port()
+		if util.IsEmptyString(port) {
+			port = dockerDefaultTcpPort
+		}
+		u.Host = agentIp + ":" + port
+	}
+
+	return u.String(), nil
+}
+
+func (h *DockerHost) String() string {
+	version := h.APIVersion
+	if util.IsEmptyString(version) {
+		version = "auto"
+	}
+	return fmt.Sprintf("%s (tls=%t, api=%s)", h.ResolveHost(), h.IsTLS(), version)
+}
+
+func detectDockerHost() string {
+	if runtime.GOOS == "windows" {
+		return dockerDefaultWinHost
+	}
+
+	for _, sock := range []string{"/var/run/docker.sock", rootlessDockerSock(), podmanSock(), "/run/podman/podman.sock"} {
+		if util.HasString(sock) && util.IsFileExists(sock) {
+			return "unix://" + sock
+		}
+	}
+
+	return dockerDefaultUnixHost
+}
+
+func rootlessDockerSock() string {
+	return xdgRuntimePath("docker.sock")
+}
+
+func podmanSock() string {
+	return xdgRuntimePath(filepath.Join("podman", "podman.sock"))
+}
+
+func xdgRuntimePath(file string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if util.IsEmptyString(dir) {
+		dir = fmt.Sprintf("/run/user/%d", os.Getuid())
+	}
+	return filepath.Join(dir, file)
+}
@@ -0,0 +1,85 @@
+package domain
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldResolveDockerHost(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(os.Setenv("XDG_RUNTIME_DIR", "/run/user/1000"))
+	defer os.Unsetenv("XDG_RUNTIME_DIR")
+
+	host := &DockerHost{Host: DockerHostRootless}
+	assert.Equal("unix:///run/user/1000/docker.sock", host.ResolveHost())
+	assert.Equal("/run/user/1000/docker.sock", host.SocketPath())
+
+	host = &DockerHost{Host: DockerHostPodman}
+	assert.Equal("unix:///run/user/1000/podman/podman.sock", host.ResolveHost())
+
+	host = &DockerHost{Host: "tcp://127.0.0.1:2376", CertPath: "/certs"}
+	assert.Equal("", host.SocketPath())
+	assert.True(host.IsTLS())
+
+	assert.NoError(os.Setenv("DOCKER_HOST", "tcp://10.0.0.1:2375"))
+	defer os.Unsetenv("DOCKER_HOST")
+
+	host = &DockerHost{}
+	assert.Equal("tcp://10.0.0.1:2375", host.ResolveHost())
+}
+
+func TestShouldGetTlsOptionsFromEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	host := &DockerHost{Host: "tcp://127.0.0.1:2376"}
+	assert.False(host.IsTLS())
+	assert.NoError(host.Validate())
+
+	assert.NoError(os.Setenv("DOCKER_TLS_VERIFY", "1"))
+	defer os.Unsetenv("DOCKER_TLS_VERIFY")
+
+	assert.True(host.IsTLS())
+	assert.True(host.IsTLSVerify())
+	assert.Error(host.Validate())
+
+	assert.NoError(os.Setenv("DOCKER_CERT_PATH", "/env/certs"))
+	defer os.Unsetenv("DOCKER_CERT_PATH")
+
+	assert.Equal("/env/certs", host.GetCertPath())
+	assert.NoError(host.Validate())
+
+	// option first
+	host.CertPath = "/certs"
+	assert.Equal("/certs", host.GetCertPath())
+
+	// tls verify without cert path
+	assert.NoError(os.Unsetenv("DOCKER_CERT_PATH"))
+	assert.Error((&DockerHost{TLSVerify: true}).Validate())
+}
+
+func TestShouldGetDockerHostForContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	host := &DockerHost{Host: "tcp://localhost:2376"}
+	val, err := host.HostForContainer("10.1.1.2")
+	assert.NoError(err)
+	assert.Equal("tcp://10.1.1.2:2376", val)
+
+	host = &DockerHost{Host: "tcp://192.168.1.5:2375"}
+	val, err = host.HostForContainer("10.1.1.2")
+	assert.NoError(err)
+	assert.Equal("tcp://192.168.1.5:2375", val)
+
+	host = &DockerHost{Host: "unix:///run/user/1000/docker.sock"}
+	val, err = host.HostForContainer("")
+	assert.NoError(err)
+	assert.Equal("unix:///var/run/docker.sock", val)
+
+	host = &DockerHost{Host: DockerSidecarHost}
+	val, err = host.HostForContainer("10.1.1.2")
+	assert.NoError(err)
+	assert.Equal("tcp://10.1.1.2:2375", val)
+}
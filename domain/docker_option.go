@@ -1,26 +1,51 @@
 package domain
 
 import (
+	"fmt"
+	"net"
+	"strings"
+
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/strslice"
 	"github.com/docker/go-connections/nat"
+	"github.com/docker/go-units"
 	"github.com/flowci/flow-agent-x/util"
 )
 
+const (
+	DockerPullAlways       = "always"
+	DockerPullIfNotPresent = "if-not-present"
+	DockerPullNever        = "never"
+)
+
 type (
 	DockerOption struct {
-		Image             string    `json:"image"`
-		Auth              string    `json:"auth"`
-		Name              string    `json:"name"`
-		Entrypoint        []string  `json:"entrypoint"` // host:container
-		Command           []string  `json:"command"`
-		Ports             []string  `json:"ports"`
-		Network           string    `json:"network"`
-		Environment       Variables `json:"environment"`
-		User              string    `json:"user"`
-		IsRuntime         bool      `json:"isRuntime"`
-		IsStopContainer   bool      `json:"isStopContainer"`
-		IsDeleteContainer bool      `json:"isDeleteContainer"`
-		ContainerID       string    // try to resume if container id is existed
+		Image             string             `json:"image"`      // name:tag or name@sha256:digest
+		PullPolicy        string             `json:"pullPolicy"` // always, if-not-present (default) or never
+		Auth              string             `json:"auth"`
+		Name              string             `json:"name"`
+		Entrypoint        []string           `json:"entrypoint"` // host:container
+		Command           []string           `json:"command"`
+		Ports             []string           `json:"ports"`
+		Network           string             `json:"network"`
+		Environment       Variables          `json:"environment"`
+		User              string             `json:"user"`
+		Volumes           []string           `json:"volumes"`    // src:dest[:ro|rw], src is host path or volume name
+		Tmpfs             []string           `json:"tmpfs"`      // dest[:options]
+		Memory            string             `json:"memory"`     // ex: 512m, 2g
+		Cpus              float64            `json:"cpus"`       // ex: 1.5
+		Privileged        bool               `json:"privileged"` // for docker in docker
+		CapAdd            []string           `json:"capAdd"`
+		ShmSize           string             `json:"shmSize"`    // ex: 2g
+		ExtraHosts        []string           `json:"extraHosts"` // host:ip
+		Labels            map[string]string  `json:"labels"`
+		WorkingDir        string             `json:"workingDir"`
+		Platform          string             `json:"platform"` // os/arch[/variant], verified on the image after pulled
+		HealthCheck       *DockerHealthCheck `json:"healthCheck"`
+		IsRuntime         bool               `json:"isRuntime"`
+		IsStopContainer   bool               `json:"isStopContainer"`
+		IsDeleteContainer bool               `json:"isDeleteContainer"`
+		ContainerID       string             // try to resume if container id is existed
 
 		AuthContent *SimpleAuthPair // the real auth secret from 'auth' name
 	}
@@ -30,6 +55,74 @@ func (d *DockerOption) HasAuth() bool {
 	return d.Auth != ""
 }
 
+// Validate check all options, the error will contain the name of invalid option
+func (d *DockerOption) Validate() error {
+	if util.IsEmptyString(d.Image) {
+		return d.invalid("image", d.Image, fmt.Errorf("image is required"))
+	}
+
+	switch d.PullPolicy {
+	case "", DockerPullAlways, DockerPullIfNotPresent, DockerPullNever:
+	default:
+		return d.invalid("pullPolicy", d.PullPolicy, fmt.Errorf("must be one of always, if-not-present or never"))
+	}
+
+	if _, _, err := nat.ParsePortSpecs(d.Ports); err != nil {
+		return d.invalid("ports", strings.Join(d.Ports, ","), err)
+	}
+
+	if _, err := parseRAMInBytes(d.Memory); err != nil {
+		return d.invalid("memory", d.Memory, err)
+	}
+
+	if _, err := parseRAMInBytes(d.ShmSize); err != nil {
+		return d.invalid("shmSize", d.ShmSize, err)
+	}
+
+	if d.Cpus < 0 {
+		return d.invalid("cpus", fmt.Sprintf("%v", d.Cpus), fmt.Errorf("must be positive"))
+	}
+
+	for _, v := range d.Volumes {
+		if _, _, _, err := parseVolume(v); err != nil {
+			return d.invalid("volumes", v, err)
+		}
+	}
+
+	for _, t := range d.Tmpfs {
+		if dest, _ := parseTmpfs(t); !strings.HasPrefix(dest, "/") {
+			return d.invalid("tmpfs", t, fmt.Errorf("path must be absolute"))
+		}
+	}
+
+	for _, h := range d.ExtraHosts {
+		if err := validateExtraHost(h); err != nil {
+			return d.invalid("extraHosts", h, err)
+		}
+	}
+
+	for _, c := range d.CapAdd {
+		if util.IsEmptyString(strings.TrimSpace(c)) {
+			return d.invalid("capAdd", c, fmt.Errorf("capability is empty"))
+		}
+	}
+
+	if util.HasString(d.Platform) {
+		items := strings.Split(d.Platform, "/")
+		if len(items) < 2 || len(items) > 3 || util.IsEmptyString(items[0]) || util.IsEmptyString(items[1]) {
+			return d.invalid("platform", d.Platform, fmt.Errorf("must be os/arch[/variant]"))
+		}
+	}
+
+	if d.HealthCheck != nil {
+		if err := d.HealthCheck.Validate(); err != nil {
+			return d.invalid("healthCheck", fmt.Sprintf("%+v", *d.HealthCheck), err)
+		}
+	}
+
+	return nil
+}
+
 func (d *DockerOption) ToRuntimeConfig(vars Variables, workingDir string, binds []string) *DockerConfig {
 	return d.toConfig(vars, workingDir, binds, true)
 }
@@ -45,8 +138,9 @@ func (d *DockerOption) SetDefaultNetwork(network string) {
 }
 
 func (d *DockerOption) toConfig(vars Variables, workingDir string, binds []string, enableInput bool) (config *DockerConfig) {
-	portSet, portMap, err := nat.ParsePortSpecs(d.Ports)
-	util.PanicIfErr(err)
+	portSet, portMap, _ := nat.ParsePortSpecs(d.Ports)
+	memory, _ := parseRAMInBytes(d.Memory)
+	shmSize, _ := parseRAMInBytes(d.ShmSize)
 
 	vars = ConnectVars(vars, d.Environment)
 
@@ -59,6 +153,7 @@ func (d *DockerOption) toConfig(vars Variables, workingDir string, binds []strin
 			Cmd:          d.Command,
 			ExposedPorts: portSet,
 			User:         d.User,
+			Labels:       d.Labels,
 			Tty:          false,
 			AttachStdin:  enableInput,
 			AttachStderr: enableInput,
@@ -69,20 +164,121 @@ func (d *DockerOption) toConfig(vars Variables, workingDir string, binds []strin
 		Host: &container.HostConfig{
 			NetworkMode:  container.NetworkMode(d.Network),
 			PortBindings: portMap,
+			Privileged:   d.Privileged,
+			CapAdd:       strslice.StrSlice(d.CapAdd),
+			ShmSize:      shmSize,
+			ExtraHosts:   d.ExtraHosts,
+			Resources: container.Resources{
+				Memory:   memory,
+				NanoCPUs: int64(d.Cpus * 1e9),
+			},
 		},
+		Platform:    d.Platform,
+		PullPolicy:  d.getPullPolicy(),
+		HealthCheck: d.HealthCheck,
 		IsStop:      d.IsStopContainer,
 		IsDelete:    d.IsDeleteContainer,
 		ContainerID: d.ContainerID,
 		Auth:        d.AuthContent,
 	}
 
+	if d.HealthCheck != nil {
+		config.Config.Healthcheck = d.HealthCheck.ToConfig()
+	}
+
 	if util.HasString(workingDir) {
 		config.Config.WorkingDir = workingDir
 	}
 
-	if binds != nil {
-		config.Host.Binds = binds
+	if util.HasString(d.WorkingDir) {
+		config.Config.WorkingDir = d.WorkingDir
+	}
+
+	config.Host.Binds = append(config.Host.Binds, binds...)
+	config.Host.Binds = append(config.Host.Binds, d.Volumes...)
+
+	if len(d.Tmpfs) > 0 {
+		config.Host.Tmpfs = make(map[string]string, len(d.Tmpfs))
+		for _, t := range d.Tmpfs {
+			dest, options := parseTmpfs(t)
+			config.Host.Tmpfs[dest] = options
+		}
+	}
+
+	return
+}
+
+func (d *DockerOption) getPullPolicy() string {
+	if util.IsEmptyString(d.PullPolicy) {
+		return DockerPullIfNotPresent
+	}
+	return d.PullPolicy
+}
+
+func (d *DockerOption) invalid(option, value string, err error) error {
+	name := d.Name
+	if util.IsEmptyString(name) {
+		name = d.Image
+	}
+
+	return fmt.Errorf("docker '%s': invalid option '%s' = '%s', %s", name, option, value, err.Error())
+}
+
+func parseRAMInBytes(size string) (int64, error) {
+	if util.IsEmptyString(size) {
+		return 0, nil
+	}
+	return units.RAMInBytes(size)
+}
+
+// parse volume src:dest[:ro|rw], the src might contain ':' on windows host, ex: C:\ws:/ws
+func parseVolume(volume string) (src, dest, mode string, err error) {
+	val := volume
+	if strings.HasSuffix(val, ":ro") || strings.HasSuffix(val, ":rw") {
+		mode = val[len(val)-2:]
+		val = val[:len(val)-3]
+	}
+
+	index := strings.LastIndex(val, ":")
+	if index <= 0 {
+		err = fmt.Errorf("must be src:dest[:ro|rw]")
+		return
+	}
+
+	src = val[:index]
+	dest = val[index+1:]
+
+	if !strings.HasPrefix(dest, "/") {
+		err = fmt.Errorf("dest path must be absolute")
 	}
 
 	return
 }
+
+// parse tmpfs dest[:options]
+func parseTmpfs(tmpfs string) (dest, options string) {
+	index := strings.Index(tmpfs, ":")
+	if index == -1 {
+		return tmpfs, ""
+	}
+	return tmpfs[:index], tmpfs[index+1:]
+}
+
+// validate extra host host:ip, the ip might be ipv6 which contains ':', ex: my.host:::1
+func validateExtraHost(extraHost string) error {
+	items := strings.SplitN(extraHost, ":", 2)
+	if len(items) != 2 {
+		return fmt.Errorf("must be host:ip")
+	}
+
+	host, ip := items[0], items[1]
+	if util.IsEmptyString(host) || strings.ContainsAny(host, " \t/") {
+		return fmt.Errorf("invalid host '%s'", host)
+	}
+
+	if net.ParseIP(ip) == nil {
+		return fmt.Errorf("invalid ip '%s'", ip)
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldConvertDockerOptionToConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	option := &DockerOption{
+		Image:      "selenium/standalone-chrome",
+		Name:       "browser",
+		Volumes:    []string{"/tmp/data:/data:ro", "my-volume:/cache"},
+		Tmpfs:      []string{"/run:rw,size=64m", "/scratch"},
+		Memory:     "512m",
+		Cpus:       1.5,
+		Privileged: true,
+		CapAdd:     []string{"SYS_ADMIN"},
+		ShmSize:    "2g",
+		ExtraHosts: []string{"my.host:10.0.0.1"},
+		Labels:     map[string]string{"ci": "flow"},
+		WorkingDir: "/app",
+		Platform:   "linux/amd64",
+	}
+
+	assert.NoError(option.Validate())
+
+	config := option.ToConfig()
+	assert.Equal([]string{"/tmp/data:/data:ro", "my-volume:/cache"}, config.Host.Binds)
+	assert.Equal("rw,size=64m", config.Host.Tmpfs["/run"])
+	assert.Equal("", config.Host.Tmpfs["/scratch"])
+	assert.Equal(int64(512*1024*1024), config.Host.Memory)
+	assert.Equal(int64(1500000000), config.Host.NanoCPUs)
+	assert.True(config.Host.Privileged)
+	assert.Equal([]string{"SYS_ADMIN"}, []string(config.Host.CapAdd))
+	assert.Equal(int64(2*1024*1024*1024), config.Host.ShmSize)
+	assert.Equal([]string{"my.host:10.0.0.1"}, config.Host.ExtraHosts)
+	assert.Equal("flow", config.Config.Labels["ci"])
+	assert.Equal("/app", config.Config.WorkingDir)
+	assert.Equal("linux/amd64", config.Platform)
+
+	runtime := option.ToRuntimeConfig(nil, "/ws/flow", []string{"ws:/ws"})
+	assert.Equal([]string{"ws:/ws", "/tmp/data:/data:ro", "my-volume:/cache"}, runtime.Host.Binds)
+}
+
+func TestShouldReturnErrorWithInvalidDockerOption(t *testing.T) {
+	assert := assert.New(t)
+
+	invalid := map[string]*DockerOption{
+		"memory":     {Image: "ubuntu", Memory: "abc"},
+		"shmSize":    {Image: "ubuntu", ShmSize: "-1"},
+		"volumes":    {Image: "ubuntu", Volumes: []string{"/data"}},
+		"tmpfs":      {Image: "ubuntu", Tmpfs: []string{"run"}},
+		"extraHosts": {Image: "ubuntu", ExtraHosts: []string{"my.host"}},
+		"platform":   {Image: "ubuntu", Platform: "amd64"},
+		"ports":      {Image: "ubuntu", Ports: []string{"abc:def"}},
+		"pullPolicy": {Image: "ubuntu", PullPolicy: "sometimes"},
+	}
+
+	for option, docker := range invalid {
+		err := docker.Validate()
+		assert.Error(err)
+		assert.Contains(err.Error(), "'"+option+"'")
+		assert.Contains(err.Error(), "docker 'ubuntu'")
+	}
+}
+
+func TestShouldValidateExtraHosts(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := []string{"my.host:10.0.0.1", "my.host:::1", "my-host:2001:db8::1"}
+	for _, h := range valid {
+		assert.NoError(validateExtraHost(h), h)
+	}
+
+	invalid := []string{"my.host", ":10.0.0.1", "my host:10.0.0.1", "my.host:abc", "my.host:"}
+	for _, h := range invalid {
+		assert.Error(validateExtraHost(h), h)
+	}
+}
+
+func TestShouldSetDefaultPullPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	option := &DockerOption{Image: "ubuntu@sha256:abc"}
+	assert.Equal(DockerPullIfNotPresent, option.ToConfig().PullPolicy)
+
+	option.PullPolicy = DockerPullAlways
+	assert.Equal(DockerPullAlways, option.ToConfig().PullPolicy)
+}
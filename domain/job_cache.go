@@ -1,12 +1,13 @@
 package domain
 
 type JobCache struct {
-	Id     string   `json:"id"`
-	FlowId string   `json:"flowId"`
-	JobId  string   `json:"jobId"`
-	Key    string   `json:"key"`
-	Os     string   `json:"os"`
-	Files  []string `json:"files"`
+	Id        string            `json:"id"`
+	FlowId    string            `json:"flowId"`
+	JobId     string            `json:"jobId"`
+	Key       string            `json:"key"`
+	Os        string            `json:"os"`
+	Files     []string          `json:"files"`
+	Checksums map[string]string `json:"checksums"` // sha256 of archive of each file, the local store is used only if it's present
 }
 
 type JobCacheResponse struct {
@@ -21,3 +22,17 @@ func (r *JobCacheResponse) IsOk() bool {
 func (r *JobCacheResponse) GetMessage() string {
 	return r.Message
 }
+
+// CacheFormatsResponse archive formats of cache supported by server
+type CacheFormatsResponse struct {
+	Response
+	Data []string
+}
+
+func (r *CacheFormatsResponse) IsOk() bool {
+	return r.Code == ok
+}
+
+func (r *CacheFormatsResponse) GetMessage() string {
+	return r.Message
+}
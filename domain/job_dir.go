@@ -0,0 +1,23 @@
+package domain
+
+import "fmt"
+
+const (
+	JobDirModeFlow = "flow"     // <workspace>/<flowId>, shared by all jobs of the flow
+	JobDirModeJob  = "job"      // <workspace>/<flowId>/<jobId>
+	JobDirModeCopy = "job-copy" // job scoped, copy files from last successful job dir if it's empty
+	JobDirModeLink = "job-link" // job scoped, hardlink files from last successful job dir if it's empty
+)
+
+func ValidateJobDirMode(mode string) error {
+	switch mode {
+	case JobDirModeFlow, JobDirModeJob, JobDirModeCopy, JobDirModeLink:
+		return nil
+	default:
+		return fmt.Errorf("invalid job dir mode '%s', must be one of flow, job, job-copy or job-link", mode)
+	}
+}
+
+func IsJobScopedDir(mode string) bool {
+	return mode == JobDirModeJob || mode == JobDirModeCopy || mode == JobDirModeLink
+}
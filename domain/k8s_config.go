@@ -4,6 +4,10 @@ type K8sConfig struct {
 	Enabled   bool
 	InCluster bool
 	Namespace string
+	NodeName  string
 	PodName   string
 	PodIp     string
+
+	PodExecutor    bool   // run docker steps as k8s pods instead of docker daemon
+	WorkspaceClaim string // pvc of agent workspace, which is shared with step pods
 }
@@ -0,0 +1,122 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	pluginCommitRegex = regexp.MustCompile("^[0-9a-f]{7,40}$")
+	pluginSha256Regex = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+)
+
+type (
+	// PluginRef plugin of cmd in format of 'name' or 'name@ref', the ref is tag, branch or commit
+	PluginRef struct {
+		Name     string
+		Ref      string // empty for the HEAD of default branch
+		Commit   string // resolved commit id after loaded
+		Checksum string // expected sha256 of plugin files from server, optional
+
+		Archive    *PluginArchive  // load from archive instead of git repo if present
+		FromMirror bool            // loaded from local mirror since the source is unavailable
+		Manifest   *PluginManifest // parsed plugin.yml after loaded, nil if not provided
+	}
+
+	// PluginInfo plugin dir in the agent plugin dir
+	PluginInfo struct {
+		Name     string    `json:"name"`
+		Version  string    `json:"version"` // version in dir name, empty for the default branch
+		Dir      string    `json:"dir"`
+		Commit   string    `json:"commit"` // commit id, or 'sha256:<sha256>' for archive
+		Size     int64     `json:"size"`   // in bytes
+		LastUsed time.Time `json:"lastUsed"`
+	}
+
+	// PluginArchive plugin bundle in .tar.gz or .zip format
+	PluginArchive struct {
+		Url    string `json:"url"`
+		Sha256 string `json:"sha256"` // sha256 of the archive file
+	}
+)
+
+func ParsePluginRef(plugin string) (*PluginRef, error) {
+	items := strings.SplitN(strings.TrimSpace(plugin), "@", 2)
+
+	ref := &PluginRef{Name: items[0]}
+	if len(items) == 2 {
+		ref.Ref = items[1]
+
+		if ref.Ref == "" {
+			return nil, fmt.Errorf("plugin '%s': the version after '@' is missing", plugin)
+		}
+	}
+
+	if ref.Name == "" || strings.ContainsAny(ref.Name, `/\`) || strings.HasPrefix(ref.Name, ".") {
+		return nil, fmt.Errorf("plugin '%s': invalid plugin name", plugin)
+	}
+
+	if strings.Contains(ref.Ref, "..") {
+		return nil, fmt.Errorf("plugin '%s': invalid plugin version", plugin)
+	}
+
+	return ref, nil
+}
+
+// IsPinned the plugin is pinned to a tag, branch or commit
+func (p *PluginRef) IsPinned() bool {
+	return p.Ref != ""
+}
+
+// IsCommit the ref looks like a full or short commit id
+func (p *PluginRef) IsCommit() bool {
+	return pluginCommitRegex.MatchString(p.Ref)
+}
+
+// DirName the dir name under plugin dir, 'name' or 'name@ref' for pinned version, the ref is path escaped,
+// ex: 'feature/v2' to 'feature%2Fv2', so that it will not be the same as other refs
+func (p *PluginRef) DirName() string {
+	if !p.IsPinned() {
+		return p.Name
+	}
+	return p.Name + "@" + url.PathEscape(p.Ref)
+}
+
+// Match the plugin is 'name' for all versions, or 'name@ref' for the pinned version only
+func (p *PluginRef) Match(name, version string) bool {
+	return p.Name == name && (!p.IsPinned() || p.Ref == version)
+}
+
+func (p *PluginRef) String() string {
+	if !p.IsPinned() {
+		return p.Name
+	}
+	return p.Name + "@" + p.Ref
+}
+
+// ParsePluginDirName get name and version from plugin dir name, it's the reverse of DirName
+func ParsePluginDirName(dirName string) (name, version string) {
+	items := strings.SplitN(dirName, "@", 2)
+	if len(items) == 2 {
+		if ref, err := url.PathUnescape(items[1]); err == nil {
+			return items[0], ref
+		}
+		return items[0], items[1]
+	}
+	return items[0], ""
+}
+
+func (a *PluginArchive) Validate() error {
+	if !strings.HasPrefix(a.Url, "http://") && !strings.HasPrefix(a.Url, "https://") {
+		return fmt.Errorf("plugin archive '%s': only http or https url is supported", a.Url)
+	}
+
+	if !pluginSha256Regex.MatchString(a.Sha256) {
+		return fmt.Errorf("plugin archive '%s': sha256 is missing or invalid", a.Url)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PluginManifestFile = "plugin.yml"
+
+	PluginInputString = "string"
+	PluginInputInt    = "int"
+	PluginInputBool   = "bool"
+)
+
+type (
+	// PluginManifest the plugin.yml in the root of plugin repo
+	PluginManifest struct {
+		Name    string         `yaml:"name"`
+		Version string         `yaml:"version"`
+		Inputs  []*PluginInput `yaml:"inputs"`
+		OS      []string       `yaml:"os"` // allowed os, ex: linux, darwin, windows, empty for all
+		Docker  *PluginDocker  `yaml:"docker"`
+	}
+
+	PluginInput struct {
+		Name     string `yaml:"name"`
+		Type     string `yaml:"type"`  // string, int or bool, default is string
+		Value    string `yaml:"value"` // default value
+		Required bool   `yaml:"required"`
+	}
+
+	// PluginDocker the image that plugin runs with
+	PluginDocker struct {
+		Image string `yaml:"image"`
+	}
+)
+
+func ParsePluginManifest(raw []byte) (*PluginManifest, error) {
+	manifest := &PluginManifest{}
+	if err := yaml.Unmarshal(raw, manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %s", PluginManifestFile, err.Error())
+	}
+
+	for _, input := range manifest.Inputs {
+		if input.Name == "" {
+			return nil, fmt.Errorf("invalid %s: input name is missing", PluginManifestFile)
+		}
+
+		switch strings.ToLower(input.Type) {
+		case "", PluginInputString, PluginInputInt, PluginInputBool:
+		default:
+			return nil, fmt.Errorf("invalid %s: input '%s' type '%s' is unsupported", PluginManifestFile, input.Name, input.Type)
+		}
+	}
+
+	return manifest, nil
+}
+
+func (m *PluginManifest) HasDocker() bool {
+	return m.Docker != nil && m.Docker.Image != ""
+}
+
+// ToRuntimeOption apply plugin image to the runtime docker option, the other options are kept as services.
+// a new runtime option will be created if no docker option
+func (m *PluginManifest) ToRuntimeOption(dockers []*DockerOption) []*DockerOption {
+	for i, option := range dockers {
+		if option.IsRuntime || len(dockers) == 1 {
+			runtime := *option
+			runtime.Image = m.Docker.Image
+			runtime.IsRuntime = true
+			runtime.Auth = ""
+			runtime.AuthContent = nil
+			runtime.Platform = ""
+			runtime.ContainerID = ""
+
+			applied := append([]*DockerOption{}, dockers...)
+			applied[i] = &runtime
+			return applied
+		}
+	}
+
+	runtime := &DockerOption{
+		Image:             m.Docker.Image,
+		IsRuntime:         true,
+		IsStopContainer:   true,
+		IsDeleteContainer: true,
+	}
+
+	return append([]*DockerOption{runtime}, dockers...)
+}
+
+// IsSupportedOS check the plugin can be run on the os, ex: linux, darwin, windows
+func (m *PluginManifest) IsSupportedOS(os string) bool {
+	if len(m.OS) == 0 {
+		return true
+	}
+
+	for _, item := range m.OS {
+		if strings.EqualFold(item, os) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ApplyInputs put default value into inputs if not present, and validate required inputs and types
+func (m *PluginManifest) ApplyInputs(inputs Variables) error {
+	var missing []string
+	var invalid []string
+
+	for _, input := range m.Inputs {
+		value, ok := inputs[input.Name]
+		if !ok || value == "" {
+			value = input.Value
+		}
+
+		if value == "" {
+			if input.Required {
+				missing = append(missing, input.Name)
+			}
+			continue
+		}
+
+		if err := input.validate(value); err != nil {
+			invalid = append(invalid, err.Error())
+			continue
+		}
+
+		inputs[input.Name] = value
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("required inputs missing: %s", strings.Join(missing, ", "))
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid inputs: %s", strings.Join(invalid, ", "))
+	}
+
+	return nil
+}
+
+func (i *PluginInput) validate(value string) error {
+	var err error
+
+	switch strings.ToLower(i.Type) {
+	case PluginInputInt:
+		_, err = strconv.Atoi(value)
+	case PluginInputBool:
+		_, err = strconv.ParseBool(value)
+	}
+
+	if err != nil {
+		return fmt.Errorf("'%s' should be %s but '%s'", i.Name, i.Type, value)
+	}
+
+	return nil
+}
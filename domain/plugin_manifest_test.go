@@ -0,0 +1,84 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testPluginManifest = `
+name: gitclone
+version: 1.0.0
+os:
+  - linux
+  - darwin
+inputs:
+  - name: GIT_URL
+    required: true
+  - name: GIT_BRANCH
+    value: master
+  - name: GIT_DEPTH
+    type: int
+    value: "1"
+docker:
+  image: flowci/git:latest
+`
+
+func TestShouldParsePluginManifestAndApplyInputs(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest, err := ParsePluginManifest([]byte(testPluginManifest))
+	assert.NoError(err)
+	assert.Equal("gitclone", manifest.Name)
+	assert.Len(manifest.Inputs, 3)
+	assert.Equal("flowci/git:latest", manifest.Docker.Image)
+
+	assert.True(manifest.IsSupportedOS("linux"))
+	assert.False(manifest.IsSupportedOS("windows"))
+
+	// required input missing
+	inputs := NewVariables()
+	err = manifest.ApplyInputs(inputs)
+	assert.Error(err)
+	assert.Equal("required inputs missing: GIT_URL", err.Error())
+
+	// apply defaults
+	inputs["GIT_URL"] = "https://github.com/flowci/flow-agent-x.git"
+	inputs["GIT_BRANCH"] = "develop"
+	assert.NoError(manifest.ApplyInputs(inputs))
+	assert.Equal("develop", inputs["GIT_BRANCH"])
+	assert.Equal("1", inputs["GIT_DEPTH"])
+
+	// invalid type
+	inputs["GIT_DEPTH"] = "abc"
+	assert.Error(manifest.ApplyInputs(inputs))
+
+	_, err = ParsePluginManifest([]byte("inputs:\n  - name: A\n    type: list\n"))
+	assert.Error(err)
+}
+
+func TestShouldApplyPluginImageAsRuntime(t *testing.T) {
+	assert := assert.New(t)
+
+	manifest := &PluginManifest{Docker: &PluginDocker{Image: "flowci/git:latest"}}
+	assert.True(manifest.HasDocker())
+
+	// host shell step
+	dockers := manifest.ToRuntimeOption(nil)
+	assert.Len(dockers, 1)
+	assert.Equal("flowci/git:latest", dockers[0].Image)
+	assert.True(dockers[0].IsRuntime)
+	assert.True(dockers[0].IsDeleteContainer)
+
+	// replace image of runtime and keep services
+	runtime := &DockerOption{Image: "ubuntu:18.04", Auth: "my-auth", IsRuntime: true, User: "root"}
+	service := &DockerOption{Image: "mysql:5.7"}
+
+	dockers = manifest.ToRuntimeOption([]*DockerOption{service, runtime})
+	assert.Len(dockers, 2)
+	assert.Equal("mysql:5.7", dockers[0].Image)
+	assert.Equal("flowci/git:latest", dockers[1].Image)
+	assert.Equal("root", dockers[1].User)
+	assert.Empty(dockers[1].Auth)
+	assert.Equal("ubuntu:18.04", runtime.Image)
+}
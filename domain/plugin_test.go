@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldParsePluginRef(t *testing.T) {
+	assert := assert.New(t)
+
+	ref, err := ParsePluginRef("gitclone")
+	assert.NoError(err)
+	assert.False(ref.IsPinned())
+	assert.Equal("gitclone", ref.DirName())
+
+	ref, err = ParsePluginRef("gitclone@feature/v2")
+	assert.NoError(err)
+	assert.True(ref.IsPinned())
+	assert.False(ref.IsCommit())
+	assert.Equal("feature/v2", ref.Ref)
+	assert.Equal("gitclone@feature%2Fv2", ref.DirName())
+
+	name, version := ParsePluginDirName(ref.DirName())
+	assert.Equal("gitclone", name)
+	assert.Equal("feature/v2", version)
+
+	other, err := ParsePluginRef("gitclone@feature_v2")
+	assert.NoError(err)
+	assert.NotEqual(ref.DirName(), other.DirName())
+
+	ref, err = ParsePluginRef("gitclone@1a2b3c4")
+	assert.NoError(err)
+	assert.True(ref.IsCommit())
+
+	for _, invalid := range []string{"", "@v1", "gitclone@", "../gitclone", "a/b@v1", "gitclone@../v1"} {
+		_, err = ParsePluginRef(invalid)
+		assert.Error(err, invalid)
+	}
+}
@@ -0,0 +1,47 @@
+package domain
+
+import "fmt"
+
+type (
+	// ResourceLimit resource limits applied on the step process, zero value for unlimited
+	ResourceLimit struct {
+		Cpu    float64 `json:"cpu"`    // number of cpu cores, ex: 1.5
+		Memory int64   `json:"memory"` // in MB
+		Pids   int64   `json:"pids"`   // max number of processes
+	}
+)
+
+func (r *ResourceLimit) HasLimit() bool {
+	return r != nil && (r.Cpu > 0 || r.Memory > 0 || r.Pids > 0)
+}
+
+// WithDefault return new limit that unset fields are taken from default
+func (r *ResourceLimit) WithDefault(def *ResourceLimit) *ResourceLimit {
+	merged := &ResourceLimit{}
+
+	if def != nil {
+		*merged = *def
+	}
+
+	if r == nil {
+		return merged
+	}
+
+	if r.Cpu > 0 {
+		merged.Cpu = r.Cpu
+	}
+
+	if r.Memory > 0 {
+		merged.Memory = r.Memory
+	}
+
+	if r.Pids > 0 {
+		merged.Pids = r.Pids
+	}
+
+	return merged
+}
+
+func (r *ResourceLimit) String() string {
+	return fmt.Sprintf("cpu=%v, memory=%dMB, pids=%d", r.Cpu, r.Memory, r.Pids)
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldMergeResourceLimitWithDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	def := &ResourceLimit{Cpu: 2, Memory: 1024, Pids: 100}
+
+	merged := (&ResourceLimit{Memory: 512}).WithDefault(def)
+	assert.Equal(float64(2), merged.Cpu)
+	assert.Equal(int64(512), merged.Memory)
+	assert.Equal(int64(100), merged.Pids)
+
+	var nilLimit *ResourceLimit
+	assert.False(nilLimit.HasLimit())
+	assert.Equal(def, nilLimit.WithDefault(def))
+	assert.False(nilLimit.WithDefault(nil).HasLimit())
+}
@@ -14,6 +14,7 @@ const (
 	VarAgentWorkspace     = "FLOWCI_AGENT_WORKSPACE"
 	VarAgentJobDir        = "FLOWCI_AGENT_JOB_DIR"
 	VarAgentPluginDir     = "FLOWCI_AGENT_PLUGIN_DIR"
+	VarPluginDir          = "FLOWCI_PLUGIN_DIR" // dir of the plugin of current step, ex: {plugin dir}/name@v1.0
 	VarAgentLogDir        = "FLOWCI_AGENT_LOG_DIR"
 	VarAgentVolumes       = "FLOWCI_AGENT_VOLUMES"
 	VarAgentDockerNetwork = "FLOWCI_AGENT_DOCKER_NETWORK"
@@ -21,14 +22,39 @@ const (
 	VarAgentEnableProfile = "FLOWCI_AGENT_PROFILE_ENABLED" // boolean
 	VarAgentFromDocker    = "FLOWCI_DOCKER_AGENT"          // boolean
 
+	VarAgentStepCpuLimit    = "FLOWCI_AGENT_STEP_CPU_LIMIT"    // number of cpu cores
+	VarAgentStepMemoryLimit = "FLOWCI_AGENT_STEP_MEMORY_LIMIT" // in MB
+	VarAgentStepPidsLimit   = "FLOWCI_AGENT_STEP_PIDS_LIMIT"
+	VarAgentCgroupParent    = "FLOWCI_AGENT_CGROUP_PARENT"
+
+	VarAgentWsCleanBefore = "FLOWCI_AGENT_WS_CLEAN_BEFORE" // boolean
+	VarAgentWsCleanAfter  = "FLOWCI_AGENT_WS_CLEAN_AFTER"  // boolean
+	VarAgentWsKeepFlows   = "FLOWCI_AGENT_WS_KEEP_FLOWS"
+	VarAgentWsMinFreeDisk = "FLOWCI_AGENT_WS_MIN_FREE_DISK" // in MB
+	VarAgentJobDirMode    = "FLOWCI_AGENT_JOB_DIR_MODE"     // flow, job, job-copy or job-link
+	VarAgentPluginMirror  = "FLOWCI_AGENT_PLUGIN_MIRROR"    // local dir of plugin archives or git repos
+	VarAgentPluginGcDays  = "FLOWCI_AGENT_PLUGIN_GC_DAYS"   // remove plugins unused for N days
+	VarAgentCacheMaxSize  = "FLOWCI_AGENT_CACHE_MAX_SIZE"   // in MB, max size of local cache store
+
+	VarAgentDockerHost       = "FLOWCI_AGENT_DOCKER_HOST" // unix://, tcp://, rootless or podman
+	VarAgentDockerTlsVerify  = "FLOWCI_AGENT_DOCKER_TLS_VERIFY"
+	VarAgentDockerCertPath   = "FLOWCI_AGENT_DOCKER_CERT_PATH"
+	VarAgentDockerApiVersion = "FLOWCI_AGENT_DOCKER_API_VERSION"
+
 	VarK8sEnabled   = "FLOWCI_AGENT_K8S_ENABLED"    // boolean
 	VarK8sInCluster = "FLOWCI_AGENT_K8S_IN_CLUSTER" // boolean
 
+	VarK8sPodExecutor    = "FLOWCI_AGENT_K8S_POD_EXECUTOR"    // boolean
+	VarK8sWorkspaceClaim = "FLOWCI_AGENT_K8S_WORKSPACE_CLAIM" // pvc name of agent workspace
+
 	VarK8sNodeName  = "K8S_NODE_NAME"
 	VarK8sPodName   = "K8S_POD_NAME"
 	VarK8sPodIp     = "K8S_POD_IP"
 	VarK8sNamespace = "K8S_NAMESPACE"
 
+	VarGitCommitId = "FLOWCI_GIT_COMMIT_ID" // commit id of checkout
+	VarGitRef      = "FLOWCI_GIT_REF"       // branch or tag of checkout
+
 	VarAgentIpPattern           = "FLOWCI_AGENT_IP_%s"        // ip address of agent host
 	VarExportContainerIdPattern = "export CONTAINER_ID_%d=%s" // container id , d=index of dockers
 	VarExportContainerIpPattern = "export CONTAINER_IP_%d=%s" // container ip , d=index of dockers
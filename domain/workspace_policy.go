@@ -0,0 +1,32 @@
+package domain
+
+import "fmt"
+
+type (
+	// WorkspacePolicy cleanup and eviction policies of flow dirs in the agent workspace
+	WorkspacePolicy struct {
+		CleanBefore bool  // clean flow dir before the first step of job
+		CleanAfter  bool  // clean flow dir of previous job when next job arrived
+		KeepFlows   int   // keep the N most recently used flow dirs, 0 for unlimited
+		MinFreeDisk int64 // in MB, evict least recently used flow dirs if free disk under it, 0 for disabled
+	}
+
+	// WorkspaceCleanup the actions applied on workspace before running the cmd
+	WorkspaceCleanup struct {
+		Policy     *WorkspacePolicy
+		CleanFlows []string // flow dirs to be cleaned
+	}
+)
+
+func (p *WorkspacePolicy) HasEviction() bool {
+	return p != nil && (p.KeepFlows > 0 || p.MinFreeDisk > 0)
+}
+
+func (p *WorkspacePolicy) String() string {
+	return fmt.Sprintf("cleanBefore=%t, cleanAfter=%t, keepFlows=%d, minFreeDisk=%dMB",
+		p.CleanBefore, p.CleanAfter, p.KeepFlows, p.MinFreeDisk)
+}
+
+func (c *WorkspaceCleanup) HasAction() bool {
+	return c != nil && (len(c.CleanFlows) > 0 || c.Policy.HasEviction())
+}
@@ -0,0 +1,154 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+)
+
+const (
+	cgroupCpuPeriod      = 100000
+	cgroupRemoveRetry    = 10
+	cgroupControllers    = "cgroup.controllers"
+	cgroupSubtreeControl = "cgroup.subtree_control"
+)
+
+var (
+	// cgroupRoot the mount point of cgroup v2 unified hierarchy
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+type cgroup struct {
+	path  string
+	limit *domain.ResourceLimit
+}
+
+// newCgroup create cgroup v2 {root}/{parent}/{name} with the limits
+func newCgroup(parent, name string, limit *domain.ResourceLimit) (*cgroup, error) {
+	if !util.IsFileExists(filepath.Join(cgroupRoot, cgroupControllers)) {
+		return nil, ErrCgroupNotSupported
+	}
+
+	parentPath := filepath.Join(cgroupRoot, parent)
+	if err := os.MkdirAll(parentPath, 0755); err != nil {
+		return nil, err
+	}
+
+	// enable controllers from root to the parent
+	controllers := "+cpu +memory +pids"
+	for _, dir := range cgroupDirsTo(parentPath) {
+		if err := writeCgroupFile(dir, cgroupSubtreeControl, controllers); err != nil {
+			return nil, fmt.Errorf("unable to enable cgroup controllers on %s: %s", dir, err.Error())
+		}
+	}
+
+	c := &cgroup{
+		path:  filepath.Join(parentPath, name),
+		limit: limit,
+	}
+
+	if err := os.MkdirAll(c.path, 0755); err != nil {
+		return nil, err
+	}
+
+	if limit.Cpu > 0 {
+		quota := int64(limit.Cpu * cgroupCpuPeriod)
+		if err := c.write("cpu.max", fmt.Sprintf("%d %d", quota, cgroupCpuPeriod)); err != nil {
+			return nil, err
+		}
+	}
+
+	if limit.Memory > 0 {
+		if err := c.write("memory.max", strconv.FormatInt(limit.Memory*1024*1024, 10)); err != nil {
+			return nil, err
+		}
+
+		// swap is optional
+		_ = c.write("memory.swap.max", "0")
+	}
+
+	if limit.Pids > 0 {
+		if err := c.write("pids.max", strconv.FormatInt(limit.Pids, 10)); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// cgroupDirsTo list dirs from cgroup root down to the path, both included
+func cgroupDirsTo(path string) []string {
+	root := filepath.Clean(cgroupRoot)
+
+	var dirs []string
+	for dir := filepath.Clean(path); len(dir) >= len(root); dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+		if dir == root {
+			break
+		}
+	}
+
+	return dirs
+}
+
+// addProcess move process into the cgroup, the children forked afterwards are in the cgroup as well
+func (c *cgroup) addProcess(pid int) error {
+	return c.write("cgroup.procs", strconv.Itoa(pid))
+}
+
+// isOOMKilled check memory.events if any process been killed by oom killer
+func (c *cgroup) isOOMKilled() bool {
+	f, err := os.Open(filepath.Join(c.path, "memory.events"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != "oom_kill" {
+			continue
+		}
+
+		n, _ := strconv.Atoi(fields[1])
+		return n > 0
+	}
+
+	return false
+}
+
+// remove kill all remaining processes and delete the cgroup
+func (c *cgroup) remove() {
+	_ = c.write("cgroup.kill", "1")
+
+	for i := 0; i < cgroupRemoveRetry; i++ {
+		err := os.Remove(c.path)
+		if err == nil || os.IsNotExist(err) {
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	util.LogWarn("Unable to remove cgroup %s", c.path)
+}
+
+func (c *cgroup) write(file, value string) error {
+	return writeCgroupFile(c.path, file, value)
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
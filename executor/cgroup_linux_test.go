@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldCreateCgroupWithLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	root, err := ioutil.TempDir("", "test_cgroup_")
+	assert.NoError(err)
+	defer os.RemoveAll(root)
+
+	defaultRoot := cgroupRoot
+	cgroupRoot = root
+	defer func() {
+		cgroupRoot = defaultRoot
+	}()
+
+	// should not supported if no cgroup v2 controllers
+	_, err = newCgroup("agent", "step", &domain.ResourceLimit{Cpu: 1})
+	assert.Equal(ErrCgroupNotSupported, err)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(root, cgroupControllers), []byte("cpu memory pids"), 0644))
+
+	cg, err := newCgroup("agent", "step", &domain.ResourceLimit{Cpu: 1.5, Memory: 10, Pids: 20})
+	assert.NoError(err)
+
+	read := func(file string) string {
+		content, err := ioutil.ReadFile(filepath.Join(cg.path, file))
+		assert.NoError(err)
+		return string(content)
+	}
+
+	assert.Equal("150000 100000", read("cpu.max"))
+	assert.Equal("10485760", read("memory.max"))
+	assert.Equal("20", read("pids.max"))
+	assert.Equal("+cpu +memory +pids", read("../"+cgroupSubtreeControl))
+
+	// should delegate controllers on every level of nested parent
+	_, err = newCgroup("a/b", "step", &domain.ResourceLimit{Cpu: 1})
+	assert.NoError(err)
+
+	for _, dir := range []string{root, filepath.Join(root, "a"), filepath.Join(root, "a", "b")} {
+		content, err := ioutil.ReadFile(filepath.Join(dir, cgroupSubtreeControl))
+		assert.NoError(err)
+		assert.Equal("+cpu +memory +pids", string(content))
+	}
+
+	assert.NoError(cg.addProcess(1234))
+	assert.Equal("1234", read("cgroup.procs"))
+
+	// should detect oom kill from memory.events
+	assert.False(cg.isOOMKilled())
+	assert.NoError(cg.write("memory.events", "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n"))
+	assert.True(cg.isOOMKilled())
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+// +build !linux
+
+package executor
+
+import "github.com/flowci/flow-agent-x/domain"
+
+// cgroup is only available on linux
+type cgroup struct{}
+
+func newCgroup(parent, name string, limit *domain.ResourceLimit) (*cgroup, error) {
+	return nil, ErrCgroupNotSupported
+}
+
+func (c *cgroup) addProcess(pid int) error {
+	return ErrCgroupNotSupported
+}
+
+func (c *cgroup) isOOMKilled() bool {
+	return false
+}
+
+func (c *cgroup) remove() {
+}
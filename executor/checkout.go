@@ -0,0 +1,301 @@
+package executor
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	gossh "golang.org/x/crypto/ssh"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+const checkoutRemote = "origin"
+
+// checkout clone or fetch the git repo of cmd into dir, and export commit id and ref to vars
+func (b *BaseExecutor) checkout(dir string) {
+	if !b.inCmd.HasCheckout() {
+		return
+	}
+
+	option := b.inCmd.Checkout
+	util.PanicIfErr(option.Validate())
+
+	b.writeSingleLog(fmt.Sprintf("Checkout %s", option))
+	start := time.Now()
+
+	ref, commit, err := gitCheckout(b.context, dir, option, b.writeSingleLog)
+	util.PanicIfErr(err)
+
+	b.vars[domain.VarGitRef] = ref
+	b.vars[domain.VarGitCommitId] = commit
+	b.writeSingleLog(fmt.Sprintf("Checkout %s at %s in %s", ref, commit, time.Since(start).Round(time.Millisecond)))
+}
+
+// gitCheckout init or reuse the repo in dir, fetch the ref with depth and checkout the commit,
+// return short name of the ref and commit id
+func gitCheckout(ctx context.Context, dir string, option *domain.Checkout, log func(string)) (ref, commit string, out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = fmt.Errorf("checkout: %s", e.Error())
+	})
+
+	auth, err := checkoutAuth(option)
+	util.PanicIfErr(err)
+
+	repo, err := git.PlainOpen(dir)
+	if err == git.ErrRepositoryNotExists {
+		util.PanicIfErr(os.MkdirAll(dir, os.ModePerm))
+		repo, err = git.PlainInit(dir, false)
+	} else if err == nil {
+		log("Checkout: reuse existing repo")
+	}
+	util.PanicIfErr(err)
+
+	remote := setCheckoutRemote(repo, option.Url)
+
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	util.PanicIfErr(err)
+
+	name, err := findRemoteRef(refs, option.Ref)
+	util.PanicIfErr(err)
+
+	local := name
+	if name.IsBranch() {
+		local = plumbing.NewRemoteReferenceName(checkoutRemote, name.Short())
+	}
+
+	err = repo.FetchContext(ctx, &git.FetchOptions{
+		RemoteName: checkoutRemote,
+		RefSpecs:   []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", name, local))},
+		Depth:      option.Depth,
+		Auth:       auth,
+		Tags:       git.NoTags,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		panic(err)
+	}
+
+	revision := plumbing.Revision(local)
+	if util.HasString(option.Commit) {
+		revision = plumbing.Revision(option.Commit)
+	}
+
+	hash, err := repo.ResolveRevision(revision)
+	if err != nil {
+		panic(fmt.Errorf("commit %s not found from %s within depth %d", revision, name.Short(), option.Depth))
+	}
+
+	worktree, err := repo.Worktree()
+	util.PanicIfErr(err)
+
+	err = worktree.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true})
+	util.PanicIfErr(err)
+
+	if option.Submodules {
+		submodules, err := worktree.Submodules()
+		util.PanicIfErr(err)
+
+		err = submodules.UpdateContext(ctx, &git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			Auth:              auth,
+		})
+		util.PanicIfErr(err)
+		log(fmt.Sprintf("Checkout: %d submodules updated", len(submodules)))
+	}
+
+	if option.Lfs {
+		util.PanicIfErr(checkoutLfs(ctx, dir, option, log))
+	}
+
+	return name.Short(), hash.String(), nil
+}
+
+// setCheckoutRemote create origin remote, or recreate it if url been changed
+func setCheckoutRemote(repo *git.Repository, url string) *git.Remote {
+	remote, err := repo.Remote(checkoutRemote)
+	if err == nil && remote.Config().URLs[0] == url {
+		return remote
+	}
+
+	if err == nil {
+		util.PanicIfErr(repo.DeleteRemote(checkoutRemote))
+	}
+
+	remote, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: checkoutRemote,
+		URLs: []string{url},
+	})
+	util.PanicIfErr(err)
+	return remote
+}
+
+// findRemoteRef find full ref name from remote refs, the default branch is applied if ref is empty
+func findRemoteRef(refs []*plumbing.Reference, ref string) (plumbing.ReferenceName, error) {
+	names := make(map[plumbing.ReferenceName]*plumbing.Reference, len(refs))
+	for _, r := range refs {
+		names[r.Name()] = r
+	}
+
+	if util.IsEmptyString(ref) {
+		head, ok := names[plumbing.HEAD]
+		if !ok {
+			return "", fmt.Errorf("HEAD not found from remote")
+		}
+
+		if head.Type() == plumbing.SymbolicReference {
+			return head.Target(), nil
+		}
+
+		// server without symref capability, find branch by hash of HEAD
+		for _, r := range refs {
+			if r.Name().IsBranch() && r.Hash() == head.Hash() {
+				return r.Name(), nil
+			}
+		}
+
+		return "", fmt.Errorf("default branch not found from remote")
+	}
+
+	candidates := []plumbing.ReferenceName{
+		plumbing.NewBranchReferenceName(ref),
+		plumbing.NewTagReferenceName(ref),
+	}
+
+	if strings.HasPrefix(ref, "refs/") {
+		candidates = []plumbing.ReferenceName{plumbing.ReferenceName(ref)}
+	}
+
+	for _, name := range candidates {
+		if _, ok := names[name]; ok {
+			return name, nil
+		}
+	}
+
+	return "", fmt.Errorf("ref '%s' not found from remote", ref)
+}
+
+// checkoutAuth get auth method from secret content, nil for anonymous or ssh agent
+func checkoutAuth(option *domain.Checkout) (transport.AuthMethod, error) {
+	if option.IsSSH() {
+		if option.KeyContent == nil {
+			return nil, nil
+		}
+
+		keys, err := ssh.NewPublicKeys(checkoutSSHUser(option.Url), []byte(option.KeyContent.PrivateKey), "")
+		if err != nil {
+			return nil, err
+		}
+
+		// verify host by known_hosts, skip verification only if it's opt-in by insecureHostKey
+		callback, err := ssh.NewKnownHostsCallback()
+		if err != nil && !option.InsecureHostKey {
+			return nil, fmt.Errorf("known_hosts is required to verify ssh host: %s", err.Error())
+		}
+
+		if err != nil {
+			callback = gossh.InsecureIgnoreHostKey()
+		}
+
+		keys.HostKeyCallback = callback
+
+		return keys, nil
+	}
+
+	if option.AuthContent == nil {
+		return nil, nil
+	}
+
+	return &http.BasicAuth{
+		Username: option.AuthContent.Username,
+		Password: option.AuthContent.Password,
+	}, nil
+}
+
+// checkoutSSHUser get user from ssh url, ex: git from git@github.com:flowci/flow-agent-x.git
+func checkoutSSHUser(url string) string {
+	url = strings.TrimPrefix(url, "ssh://")
+	if index := strings.Index(url, "@"); index > 0 {
+		return url[:index]
+	}
+	return "git"
+}
+
+// checkoutLfs pull lfs files by git-lfs with credentials of the checkout
+func checkoutLfs(ctx context.Context, dir string, option *domain.Checkout, log func(string)) error {
+	if _, err := exec.LookPath("git-lfs"); err != nil {
+		log("Checkout: git-lfs not found on agent, lfs files are skipped")
+		return nil
+	}
+
+	keyFile := ""
+	if option.KeyContent != nil && option.IsSSH() {
+		f, err := ioutil.TempFile("", "checkout_key_")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		_, _ = f.WriteString(option.KeyContent.PrivateKey)
+		_ = f.Close()
+		keyFile = f.Name()
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "lfs", "pull", checkoutRemote)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), checkoutGitEnv(option, keyFile)...)
+
+	output, err := cmd.CombinedOutput()
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); util.HasString(line) {
+			log("Checkout: " + line)
+		}
+	}
+
+	return err
+}
+
+// checkoutGitEnv env for git cli, the credentials are passed by GIT_CONFIG_* instead of args
+// that can be listed from process list
+func checkoutGitEnv(option *domain.Checkout, keyFile string) []string {
+	var env []string
+
+	if option.AuthContent != nil && !option.IsSSH() {
+		pair := option.AuthContent.Username + ":" + option.AuthContent.Password
+		header := "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(pair))
+		env = append(env, "GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=http.extraHeader", "GIT_CONFIG_VALUE_0="+header)
+	}
+
+	if option.IsSSH() {
+		strict := "yes"
+		if option.InsecureHostKey {
+			strict = "no"
+		}
+
+		command := "ssh -o StrictHostKeyChecking=" + strict
+		if util.HasString(keyFile) {
+			command += fmt.Sprintf(" -i %s -o IdentitiesOnly=yes", filepath.ToSlash(keyFile))
+		}
+
+		env = append(env, "GIT_SSH_COMMAND="+command)
+	}
+
+	return env
+}
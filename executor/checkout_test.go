@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/stretchr/testify/assert"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestShouldCheckoutAndFetchFromExistingRepo(t *testing.T) {
+	assert := assert.New(t)
+
+	src, err := ioutil.TempDir("", "test_checkout_src_")
+	assert.NoError(err)
+	defer os.RemoveAll(src)
+
+	dest, err := ioutil.TempDir("", "test_checkout_dest_")
+	assert.NoError(err)
+	defer os.RemoveAll(dest)
+
+	repo, err := git.PlainInit(src, false)
+	assert.NoError(err)
+
+	first := commitTestFile(t, repo, src, "hello.txt", "v1")
+	_, err = repo.CreateTag("v1", first, nil)
+	assert.NoError(err)
+
+	var logs []string
+	log := func(msg string) { logs = append(logs, msg) }
+
+	// checkout default branch
+	option := &domain.Checkout{Url: src, Depth: 1}
+	ref, commit, err := gitCheckout(context.Background(), dest, option, log)
+	assert.NoError(err)
+	assert.Equal("master", ref)
+	assert.Equal(first.String(), commit)
+	assertFileContent(t, filepath.Join(dest, "hello.txt"), "v1")
+
+	// fetch new commit into existing repo
+	second := commitTestFile(t, repo, src, "hello.txt", "v2")
+
+	ref, commit, err = gitCheckout(context.Background(), dest, option, log)
+	assert.NoError(err)
+	assert.Equal(second.String(), commit)
+	assertFileContent(t, filepath.Join(dest, "hello.txt"), "v2")
+	assert.Contains(logs, "Checkout: reuse existing repo")
+
+	// checkout tag
+	option.Ref = "v1"
+	ref, commit, err = gitCheckout(context.Background(), dest, option, log)
+	assert.NoError(err)
+	assert.Equal("v1", ref)
+	assert.Equal(first.String(), commit)
+	assertFileContent(t, filepath.Join(dest, "hello.txt"), "v1")
+
+	// checkout commit of branch
+	option.Ref = "master"
+	option.Depth = 0
+	option.Commit = first.String()
+	_, commit, err = gitCheckout(context.Background(), dest, option, log)
+	assert.NoError(err)
+	assert.Equal(first.String(), commit)
+
+	// ref not found
+	option.Ref = "not-existed"
+	_, _, err = gitCheckout(context.Background(), dest, option, log)
+	assert.Error(err)
+}
+
+func TestShouldGetSSHUserFromUrl(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("git", checkoutSSHUser("git@github.com:flowci/flow-agent-x.git"))
+	assert.Equal("deploy", checkoutSSHUser("ssh://deploy@example.com:2222/flow.git"))
+	assert.Equal("git", checkoutSSHUser("ssh://example.com/flow.git"))
+}
+
+func TestShouldRequireKnownHostsForSSHCheckout(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(err)
+
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	assert.NoError(os.Setenv("SSH_KNOWN_HOSTS", filepath.Join(os.TempDir(), "not_existed_known_hosts")))
+	defer os.Unsetenv("SSH_KNOWN_HOSTS")
+
+	option := &domain.Checkout{
+		Url:        "git@github.com:flowci/flow-agent-x.git",
+		KeyContent: &domain.SimpleKeyPair{PrivateKey: string(privateKey)},
+	}
+
+	_, err = checkoutAuth(option)
+	assert.Error(err)
+
+	option.InsecureHostKey = true
+	auth, err := checkoutAuth(option)
+	assert.NoError(err)
+	assert.NotNil(auth)
+}
+
+func TestShouldPassCredentialsToGitByEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	option := &domain.Checkout{
+		Url:         "https://github.com/flowci/flow-agent-x.git",
+		AuthContent: &domain.SimpleAuthPair{Username: "user", Password: "pass"},
+	}
+
+	env := checkoutGitEnv(option, "")
+	assert.Equal([]string{
+		"GIT_CONFIG_COUNT=1",
+		"GIT_CONFIG_KEY_0=http.extraHeader",
+		"GIT_CONFIG_VALUE_0=Authorization: Basic dXNlcjpwYXNz",
+	}, env)
+
+	option = &domain.Checkout{Url: "git@github.com:flowci/flow-agent-x.git"}
+	assert.Equal([]string{"GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=yes -i /tmp/key -o IdentitiesOnly=yes"},
+		checkoutGitEnv(option, "/tmp/key"))
+
+	option.InsecureHostKey = true
+	assert.Equal([]string{"GIT_SSH_COMMAND=ssh -o StrictHostKeyChecking=no"}, checkoutGitEnv(option, ""))
+}
+
+func commitTestFile(t *testing.T, repo *git.Repository, dir, name, content string) plumbing.Hash {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	_, err = worktree.Add(name)
+	assert.NoError(t, err)
+
+	hash, err := worktree.Commit(content, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@flow.ci", When: time.Now()},
+	})
+	assert.NoError(t, err)
+	return hash
+}
+
+func assertFileContent(t *testing.T, path, expected string) {
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
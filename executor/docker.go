@@ -1,9 +1,9 @@
 package executor
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
@@ -12,10 +12,13 @@ import (
 	"github.com/docker/docker/api/types/volume"
 	volumetypes "github.com/docker/docker/api/types/volume"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/flowci/flow-agent-x/domain"
 	"github.com/flowci/flow-agent-x/util"
 	"io/ioutil"
 	"os"
+	"path"
+	"sort"
 	"strings"
 	"time"
 )
@@ -26,18 +29,94 @@ const (
 	dockerBin             = "/ws/bin"
 	dockerEnvFile         = "/tmp/.env"
 	dockerPullRetry       = 3
-	dockerSock            = "/var/run/docker.sock"
+	dockerCertPath        = "/etc/docker/certs"
 	dockerNetwork         = "flow-ci-agent-default"
 	dockerNetworkDriver   = "bridge"
 	dockerVarDockerHost   = "DOCKER_HOST"
+	dockerVarCertPath     = "DOCKER_CERT_PATH"
+	dockerVarTlsVerify    = "DOCKER_TLS_VERIFY"
 	dockerDefaultExitCode = -1
+	dockerLogTail         = "100"
+
+	dockerExecInspectMinDelay = 10 * time.Millisecond
+	dockerExecInspectMaxDelay = 500 * time.Millisecond
+	dockerExecInspectInterval = 5 * time.Second
 
 	dockerShellPidPath = "/tmp/.shell.pid"
+	dockerTtyPidPath   = "/tmp/.tty.pid"
 	writeShellPid      = "echo $$ > /tmp/.shell.pid\n"
 	writeTtyPid        = "echo $$ > /tmp/.tty.pid\n"
 
-	killShell = "kill -9 $(cat /tmp/.shell.pid)"
-	killTty   = "kill -9 $(cat /tmp/.tty.pid)"
+	// check tcp port by bash, use timeout if it's available in the image
+	checkTcpScriptPattern = `if command -v timeout > /dev/null; then timeout %[1]d bash -c '</dev/tcp/%[2]s/%[3]d'; else bash -c '</dev/tcp/%[2]s/%[3]d'; fi`
+
+	// args: workspace, current flow, keep flows, min free disk in MB, flows to clean
+	workspaceCleanupPattern = `ws=%s; current=%s; keep=%d; min=%d
+cd "$ws" || exit 0
+for f in %s; do
+  if [ -d "$ws/$f" ]; then rm -rf "${ws:?}/$f" && echo "Workspace: flow dir $f cleaned"; fi
+done
+mkdir -p "$ws/$current" && touch "$ws/$current"
+flows() { ls -1t "$ws" | while read -r f; do [ -d "$ws/$f" ] && [ "$f" != "$current" ] && [ "$f" != "bin" ] && echo "$f"; done; }
+if [ $keep -gt 0 ]; then
+  flows | tail -n +$keep | while read -r f; do rm -rf "${ws:?}/$f" && echo "Workspace: flow dir $f evicted, keep $keep flows"; done
+fi
+if [ $min -gt 0 ]; then
+  while true; do
+    free=$(df -Pm "$ws" | awk 'NR==2 {print $4}')
+    if [ -z "$free" ] || [ "$free" -ge $min ]; then break; fi
+    f=$(flows | tail -n 1)
+    if [ -z "$f" ]; then break; fi
+    rm -rf "${ws:?}/$f" && echo "Workspace: flow dir $f evicted, free disk ${free}MB < ${min}MB"
+  done
+fi
+exit 0`
+
+	// args: flow dir, job id, holder, step id, lease (seconds), retention, reuse mode (empty if not reuse)
+	jobDirScriptPattern = `flow=%s; job=%s; holder=%s; step=%s; lease=%d; keep=%d; reuse=%s
+mkdir -p "$flow/$job" && cd "$flow" || exit 0
+now=$(date +%%s); last=""
+for s in .job-*; do
+  j=${s#.job-}
+  [ -f "$s" ] && [ "$j" != "$job" ] || continue
+  read -r h exp failed < "$s"
+  [ "$h" = "$holder" ] || [ "${exp:-0}" -le "$now" ] || continue
+  if [ -z "$failed" ] && { [ -z "$last" ] || [ "${exp:-0}" -gt "$last" ]; }; then last=${exp:-0}; printf '%%s' "$j" > .last-success; fi
+  rm -f "$s"
+done
+failed=""; [ -f ".job-$job" ] && read -r h exp failed < ".job-$job"
+[ "$failed" = "$step" ] && failed=""
+printf '%%s %%s %%s' "$holder" "$((now + lease))" "$failed" > ".job-$job"
+src=$(cat .last-success 2>/dev/null)
+ls -1t | while read -r f; do
+  if [ -d "$f" ] && [ "$f" != "$job" ] && [ "$f" != "$src" ] && [ ! -f ".job-$f" ]; then echo "$f"; fi
+done | tail -n +$((keep + 1)) | while read -r f; do rm -rf "${flow:?}/$f" && echo "Job dir of job $f removed"; done
+if [ -n "$reuse" ] && [ -n "$src" ] && [ "$src" != "$job" ] && [ -d "$src" ] && [ -z "$(ls -A "$job")" ]; then
+  if [ "$reuse" = "job-link" ]; then cp -al "$src/." "$job/" 2>/dev/null || cp -a "$src/." "$job/"; else cp -a "$src/." "$job/"; fi
+  echo "Job dir reused from job $src ($reuse)"
+fi
+exit 0`
+
+	// args: flow dir, job id, holder, step id, lease (seconds), is step failed
+	releaseJobDirScriptPattern = `flow=%s; job=%s; holder=%s; step=%s; lease=%d; fail=%t
+cd "$flow" 2>/dev/null || exit 0
+failed=""; [ -f ".job-$job" ] && read -r h exp failed < ".job-$job"
+if [ "$fail" = "true" ]; then failed=$step; elif [ "$failed" = "$step" ]; then failed=""; fi
+printf '%%s %%s %%s' "$holder" "$(($(date +%%s) + lease))" "$failed" > ".job-$job"
+exit 0`
+
+	// kill process group of pid from file, or the process tree if it's not a group leader,
+	// send SIGTERM and then SIGKILL if any process still alive after grace period (seconds)
+	killScriptPattern = `pid=$(cat %s 2>/dev/null) || exit 0
+tree() { local c; echo $1; for c in $(cat /proc/$1/task/*/children 2>/dev/null); do tree $c; done; }
+read -r stat < /proc/$pid/stat 2>/dev/null || exit 0
+set -- ${stat##*) }
+if [ "$3" = "$pid" ]; then targets="-$pid"; else targets=$(tree $pid); fi
+alive() { local t; for t in $targets; do kill -0 -- $t 2>/dev/null && return 0; done; return 1; }
+kill -TERM -- $targets 2>/dev/null
+for ((i = 0; i < %d * 10; i++)); do alive || exit 0; sleep 0.1; done
+kill -KILL -- $targets 2>/dev/null
+exit 0`
 )
 
 var (
@@ -50,11 +129,14 @@ type (
 	dockerExecutor struct {
 		BaseExecutor
 		wsFromDockerVolume bool
+		dockerHost         *domain.DockerHost
+		wsCleanup          *domain.WorkspaceCleanup
 		wsVolume           types.Volume
-		cli                *client.Client
+		cli                *DockerClient
 		configs            []*domain.DockerConfig
 		ttyExecId          string
 		envFile            string
+		checkoutOnHost     string // the job dir on host to checkout, which is copied to job dir in volume
 	}
 )
 
@@ -74,7 +156,7 @@ func (d *dockerExecutor) Init() (out error) {
 	d.os = util.OSLinux // only support unix based image
 	d.result.StartAt = time.Now()
 
-	d.cli, out = client.NewEnvClient()
+	d.cli, out = NewDockerClient(d.context, d.dockerHost)
 	util.PanicIfErr(out)
 	util.LogInfo("Docker client version: %s", d.cli.ClientVersion())
 
@@ -87,20 +169,10 @@ func (d *dockerExecutor) Init() (out error) {
 }
 
 func (d *dockerExecutor) Start() (out error) {
-	for i := d.inCmd.Retry; i >= 0; i-- {
-		out = d.doStart()
-		r := d.result
-
-		if r.Status == domain.CmdStatusException || out != nil {
-			if i > 0 {
-				d.writeSingleLog(">>>>>>> retry >>>>>>>")
-			}
-			continue
-		}
-
-		break
+	out = d.runWithRetry(d.doStart)
+	if !d.wsFromDockerVolume || util.HasString(d.checkoutOnHost) {
+		d.releaseJobDir(d.workspace)
 	}
-
 	return
 }
 
@@ -110,19 +182,25 @@ func (d *dockerExecutor) doStart() (out error) {
 	})
 
 	defer d.cleanupContainer()
+	defer d.releaseJobDirInVolume()
 
 	// one for pull image output, and one for cmd output
 	d.stdOutWg.Add(1)
 
 	d.pullImage()
 	d.startContainer()
+	d.waitForServices()
+	d.cleanupWorkspaceVolume()
+	d.prepareJobDirInVolume()
+	d.copyCheckout()
 	d.copyPlugins()
+	d.loadCache(d.hashFilesInContainer)
 	d.copyCache()
 
-	eid := d.runShell()
+	eid, streamDone := d.runShell()
 	util.LogDebug("Exec %s is running", eid)
 
-	exitCode := d.waitForExit(d.context, eid, func(pid int) {
+	exitCode := d.waitForExit(d.context, eid, streamDone, func(pid int) {
 		d.toStartStatus(pid)
 	})
 	d.exportEnv()
@@ -172,7 +250,7 @@ func (d *dockerExecutor) StartTty(ttyId string, onStarted func(ttyId string)) (o
 	exec, err := d.cli.ContainerExecCreate(d.context, runtime.ContainerID, config)
 	util.PanicIfErr(err)
 
-	attach, err := d.cli.ContainerExecAttach(d.context, exec.ID, config)
+	attach, err := d.cli.ContainerExecAttach(d.context, exec.ID, types.ExecStartCheck{Tty: config.Tty})
 	util.PanicIfErr(err)
 
 	d.ttyExecId = exec.ID
@@ -190,15 +268,19 @@ func (d *dockerExecutor) StartTty(ttyId string, onStarted func(ttyId string)) (o
 	// write pid for tty bash
 	_, _ = attach.Conn.Write([]byte(writeTtyPid))
 
+	streamDone := make(chan struct{})
 	go d.writeTtyIn(attach.Conn)
-	go d.writeTtyOut(attach.Reader)
+	go func() {
+		defer close(streamDone)
+		d.writeTtyOut(attach.Reader)
+	}()
 
-	d.waitForExit(d.context, exec.ID, nil)
+	d.waitForExit(d.context, exec.ID, streamDone, nil)
 	return
 }
 
 func (d *dockerExecutor) StopTty() {
-	_, err := d.runSingleScript(killTty)
+	_, err := d.runSingleScript(killScript(dockerTtyPidPath, 0))
 	util.LogIfError(err)
 }
 
@@ -223,6 +305,7 @@ func (d *dockerExecutor) initVolumeData() {
 				},
 			},
 			nil,
+			nil,
 			fmt.Sprintf("%s-init", v.Name),
 		)
 		util.PanicIfErr(err)
@@ -235,7 +318,11 @@ func (d *dockerExecutor) initVolumeData() {
 		err = d.cli.ContainerStart(d.context, c.ID, types.ContainerStartOptions{})
 		util.PanicIfErr(err)
 
-		_, _ = d.cli.ContainerWait(d.context, c.ID)
+		statusCh, errCh := d.cli.ContainerWait(d.context, c.ID, container.WaitConditionNotRunning)
+		select {
+		case <-statusCh:
+		case <-errCh:
+		}
 	}
 
 	for _, v := range d.volumes {
@@ -255,11 +342,11 @@ func (d *dockerExecutor) initVolumeData() {
 		}
 
 		// pull image
-		err = d.pullImageWithName(v.Image, nil)
+		err = d.pullImageWithName(v.Image, "", nil, domain.DockerPullIfNotPresent)
 		util.PanicIfErr(err)
 
 		// create volume
-		_, err = d.cli.VolumeCreate(d.context, volumetypes.VolumesCreateBody{
+		_, err = d.cli.VolumeCreate(d.context, volumetypes.VolumeCreateBody{
 			Name: v.Name,
 		})
 		util.PanicIfErr(err)
@@ -313,17 +400,24 @@ func (d *dockerExecutor) initConfig() {
 		runtimeOption = d.inCmd.Dockers[0]
 	}
 
-	// set job work dir in the container = /ws/{flow id}
-	d.jobDir = dockerWorkspace + "/" + util.ParseString(d.inCmd.FlowId)
+	// set job work dir in the container = /ws/{flow id} or /ws/{flow id}/{job id}
+	d.jobDir = dockerWorkspace + "/" + d.jobDirName()
 	d.vars[domain.VarAgentWorkspace] = dockerWorkspace
 	d.vars[domain.VarAgentJobDir] = d.jobDir
 	d.vars[domain.VarAgentPluginDir] = dockerPluginDir
+	d.initPluginDirVar(dockerPluginDir, util.UnixPathSeparator)
 	d.vars[domain.VarAgentDockerNetwork] = dockerNetwork
 
-	// setup workspace
+	// setup workspace, the job dir and checkout in volume will be applied after container started
 	ws := d.workspace
 	if d.wsFromDockerVolume {
 		ws = d.wsVolume.Name
+		if d.inCmd.HasCheckout() {
+			d.checkoutOnHost = d.prepareJobDir(d.workspace)
+			d.checkout(d.checkoutOnHost)
+		}
+	} else {
+		d.checkout(d.prepareJobDir(ws))
 	}
 
 	// setup run time config
@@ -338,18 +432,16 @@ func (d *dockerExecutor) initConfig() {
 		binds = append(binds, v.ToBindStr())
 	}
 
-	// mount docker dock if exit or running on Windows
-	if util.IsFileExists(dockerSock) || util.IsWindows() {
-		binds = append(binds, fmt.Sprintf("%s:%s", dockerSock, dockerSock))
-	}
-
 	// set agent ip and docker host env
+	agentIp := ""
 	if d.isK8sEnabled() {
+		agentIp = d.k8sConfig.PodIp
 		agentIpKey := fmt.Sprintf(domain.VarAgentIpPattern, "en0")
-		d.vars[agentIpKey] = d.k8sConfig.PodIp
-		d.vars[dockerVarDockerHost] = fmt.Sprintf("tcp://%s:2375", d.k8sConfig.PodIp)
+		d.vars[agentIpKey] = agentIp
 	}
 
+	binds = append(binds, d.initDockerHostInContainer(agentIp)...)
+
 	d.vars.Resolve()
 	config := runtimeOption.ToRuntimeConfig(domain.ConnectVars(d.vars, d.secretVars), d.jobDir, binds)
 
@@ -362,6 +454,49 @@ func (d *dockerExecutor) initConfig() {
 	d.configs[0] = config
 }
 
+// initDockerHostInContainer export DOCKER_HOST to the runtime container and return binds of docker socket and certs
+func (d *dockerExecutor) initDockerHostInContainer(agentIp string) (binds []string) {
+	host := d.dockerHost
+	if host == nil {
+		host = &domain.DockerHost{}
+	}
+
+	// mount docker sock if exists or running on Windows
+	sock := host.SocketPath()
+	if util.IsWindows() || (util.HasString(sock) && util.IsFileExists(sock)) {
+		if util.IsEmptyString(sock) {
+			sock = domain.DockerSockInContainer
+		}
+		binds = append(binds, fmt.Sprintf("%s:%s", sock, domain.DockerSockInContainer))
+		d.vars[dockerVarDockerHost] = "unix://" + domain.DockerSockInContainer
+		return
+	}
+
+	if util.HasString(sock) {
+		if !d.isK8sEnabled() {
+			util.LogWarn("Docker socket %s not found, DOCKER_HOST is not exported to the step", sock)
+			return
+		}
+
+		// docker in docker sidecar of k8s pod
+		host = &domain.DockerHost{Host: domain.DockerSidecarHost}
+	}
+
+	dockerHost, err := host.HostForContainer(agentIp)
+	util.PanicIfErr(err)
+	d.vars[dockerVarDockerHost] = dockerHost
+
+	if host.IsTLS() {
+		binds = append(binds, fmt.Sprintf("%s:%s:ro", host.GetCertPath(), dockerCertPath))
+		d.vars[dockerVarCertPath] = dockerCertPath
+		if host.IsTLSVerify() {
+			d.vars[dockerVarTlsVerify] = "1"
+		}
+	}
+
+	return
+}
+
 // agent volume that bind to /ws inside docker
 func (d *dockerExecutor) initWorkspaceVolume() {
 	if !d.wsFromDockerVolume {
@@ -378,7 +513,7 @@ func (d *dockerExecutor) initWorkspaceVolume() {
 		return
 	}
 
-	body := volume.VolumesCreateBody{Name: name}
+	body := volume.VolumeCreateBody{Name: name}
 	created, err := d.cli.VolumeCreate(d.context, body)
 	util.PanicIfErr(err)
 
@@ -389,8 +524,9 @@ func (d *dockerExecutor) initWorkspaceVolume() {
 
 func (d *dockerExecutor) handleErrors(err error) error {
 	kill := func() {
-		_, _ = d.runSingleScript(killShell)
-		_, _ = d.runSingleScript(killTty)
+		grace := d.inCmd.GetGracePeriod()
+		_, _ = d.runSingleScript(killScript(dockerTtyPidPath, 0))
+		_, _ = d.runSingleScript(killScript(dockerShellPidPath, grace))
 	}
 
 	util.LogWarn("handleError on docker: %s", err.Error())
@@ -416,37 +552,55 @@ func (d *dockerExecutor) handleErrors(err error) error {
 }
 
 func (d *dockerExecutor) findImageLocally(image string) (bool, error) {
-	list, err := d.cli.ImageList(d.context, types.ImageListOptions{All: true})
-	if err != nil {
-		return false, err
+	_, _, err := d.cli.ImageInspectWithRaw(d.context, image)
+	if err == nil {
+		return true, nil
 	}
 
-	for _, imageInfo := range list {
-		for _, t := range imageInfo.RepoTags {
-			if t == image {
-				return true, nil
-			}
-		}
+	if client.IsErrNotFound(err) {
+		return false, nil
 	}
 
-	return false, nil
+	return false, err
 }
 
 func (d *dockerExecutor) pullImage() {
-	for _, c := range d.configs {
-		err := d.pullImageWithName(c.Config.Image, c.Auth)
+	digests := make([]string, len(d.configs))
+
+	for i, c := range d.configs {
+		image := c.Config.Image
+
+		err := d.pullImageWithName(image, c.Platform, c.Auth, c.PullPolicy)
+		util.PanicIfErr(err)
+
+		inspect, raw, err := d.cli.ImageInspectWithRaw(d.context, image)
+		util.PanicIfErr(err)
+
+		err = verifyImagePlatform(raw, image, c.Platform)
 		util.PanicIfErr(err)
+
+		digests[i] = resolveImageDigest(inspect, image)
+		d.writeSingleLog(fmt.Sprintf("Image %s resolved to %s", image, digests[i]))
 	}
+
+	d.result.ImageDigests = digests
 }
 
-func (d *dockerExecutor) pullImageWithName(image string, auth *domain.SimpleAuthPair) (out error) {
-	if isOnLocal, err := d.findImageLocally(image); isOnLocal {
-		out = err
-		return
-	}
+// pullImageWithName pull image of the platform if it's given, or the platform of daemon
+func (d *dockerExecutor) pullImageWithName(image, platform string, auth *domain.SimpleAuthPair, policy string) (out error) {
+	if policy != domain.DockerPullAlways {
+		isOnLocal, err := d.findImageLocally(image)
+		if err != nil {
+			return err
+		}
 
-	if util.HasError(out) {
-		return
+		if isOnLocal {
+			return nil
+		}
+
+		if policy == domain.DockerPullNever {
+			return fmt.Errorf("image %s not found locally and pull policy is '%s'", image, policy)
+		}
 	}
 
 	fullRef := image
@@ -458,25 +612,42 @@ func (d *dockerExecutor) pullImageWithName(image string, auth *domain.SimpleAuth
 		}
 	}
 
-	options := types.ImagePullOptions{}
-	if auth != nil {
-		jsonBytes, err := json.Marshal(auth)
+	options := types.ImagePullOptions{Platform: platform}
+	if authConfig := resolveRegistryAuth(image, auth); authConfig != nil {
+		encoded, err := encodeRegistryAuth(authConfig)
 		if err != nil {
 			return err
 		}
-		options.RegistryAuth = base64.StdEncoding.EncodeToString(jsonBytes)
+		options.RegistryAuth = encoded
 	}
 
 	for i := 0; i < dockerPullRetry; i++ {
 		reader, err := d.cli.ImagePull(d.context, fullRef, options)
 		if err != nil {
 			out = err
+			if isPermanentPullError(err) {
+				return
+			}
+
 			d.writeSingleLog(fmt.Sprintf("Unable to pull image %s since %s, retrying", image, err.Error()))
 			continue
 		}
 
-		d.writeLog(reader, false, false)
-		break
+		d.writeSingleLog(fmt.Sprintf("Pulling image %s", fullRef))
+		err = newPullProgress(d.writeSingleLog).consume(image, reader)
+		_ = reader.Close()
+
+		if err != nil {
+			out = err
+			if isPermanentPullError(err) {
+				return
+			}
+
+			d.writeSingleLog(fmt.Sprintf("%s, retrying", err.Error()))
+			continue
+		}
+
+		return nil
 	}
 
 	return
@@ -491,7 +662,7 @@ func (d *dockerExecutor) startContainer() {
 			continue
 		}
 
-		resp, err := d.cli.ContainerCreate(d.context, c.Config, c.Host, nil, c.Name)
+		resp, err := d.cli.ContainerCreate(d.context, c.Config, c.Host, nil, nil, c.Name)
 		util.PanicIfErr(err)
 
 		err = d.cli.ContainerStart(d.context, resp.ID, types.ContainerStartOptions{})
@@ -508,13 +679,113 @@ func (d *dockerExecutor) startContainer() {
 	d.result.Containers = ids
 }
 
+// wait for service containers which have health check to be healthy
+func (d *dockerExecutor) waitForServices() {
+	for i, c := range d.configs {
+		if i == 0 || c == nil || !c.HasHealthCheck() {
+			continue
+		}
+
+		d.waitForHealthy(c)
+	}
+}
+
+func (d *dockerExecutor) waitForHealthy(c *domain.DockerConfig) {
+	hc := c.HealthCheck
+	startAt := time.Now()
+	failures := 0
+
+	d.writeSingleLog(fmt.Sprintf("Waiting for container %s %s to be healthy", c.Config.Image, c.ContainerID))
+
+	for {
+		status, err := d.checkHealth(c)
+		if err != nil {
+			d.failOnUnhealthy(c, err)
+		}
+
+		if status == types.Healthy {
+			waited := time.Since(startAt).Round(100 * time.Millisecond)
+			d.writeSingleLog(fmt.Sprintf("Container %s %s is healthy after %s", c.Config.Image, c.ContainerID, waited))
+			return
+		}
+
+		// the failures in start period are not counted
+		if status == types.Unhealthy && time.Since(startAt) >= hc.GetStartPeriod() {
+			failures++
+
+			// docker reports unhealthy after retries if check by command
+			if hc.HasCommand() || failures > hc.GetRetries() {
+				d.failOnUnhealthy(c, fmt.Errorf("container %s %s is unhealthy", c.Config.Image, c.ContainerID))
+			}
+		}
+
+		select {
+		case <-d.context.Done():
+			panic(d.context.Err())
+		case <-time.After(hc.GetInterval()):
+		}
+	}
+}
+
+// checkHealth return docker health status, the port is checked from runtime container
+func (d *dockerExecutor) checkHealth(c *domain.DockerConfig) (string, error) {
+	inspect, err := d.cli.ContainerInspect(d.context, c.ContainerID)
+	if err != nil {
+		return "", err
+	}
+
+	if !inspect.State.Running {
+		return "", fmt.Errorf("container %s %s exited with code %d", c.Config.Image, c.ContainerID, inspect.State.ExitCode)
+	}
+
+	hc := c.HealthCheck
+	if hc.HasCommand() {
+		if inspect.State.Health == nil {
+			return types.Healthy, nil
+		}
+		return inspect.State.Health.Status, nil
+	}
+
+	addresses := containerAddresses(inspect)
+	if len(addresses) == 0 {
+		return types.Unhealthy, nil
+	}
+
+	script := fmt.Sprintf(checkTcpScriptPattern, int(hc.GetTimeout().Seconds()), addresses[0], hc.Port)
+	if code, _ := d.runSingleScript(script); code == 0 {
+		return types.Healthy, nil
+	}
+
+	return types.Unhealthy, nil
+}
+
+// failOnUnhealthy write container logs to step log and fail the step
+func (d *dockerExecutor) failOnUnhealthy(c *domain.DockerConfig, err error) {
+	reader, logErr := d.cli.ContainerLogs(d.context, c.ContainerID, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       dockerLogTail,
+	})
+
+	if logErr == nil {
+		var buf bytes.Buffer
+		_, _ = stdcopy.StdCopy(&buf, &buf, reader)
+		_ = reader.Close()
+
+		d.writeSingleLog(fmt.Sprintf("----- logs of container %s %s -----", c.Config.Image, c.ContainerID))
+		d.writeSingleLog(buf.String())
+	}
+
+	panic(err)
+}
+
 func (d *dockerExecutor) resume(cid string) bool {
 	if util.IsEmptyString(cid) {
 		return false
 	}
 
 	inspect, err := d.cli.ContainerInspect(d.context, cid)
-	if client.IsErrContainerNotFound(err) {
+	if client.IsErrNotFound(err) {
 		util.LogWarn("Container %s not found, will create a new one", cid)
 		return false
 	}
@@ -544,7 +815,89 @@ func (d *dockerExecutor) resume(cid string) bool {
 	return false
 }
 
+// cleanupWorkspaceVolume apply workspace policies in the runtime container since the volume is not accessible from agent
+func (d *dockerExecutor) cleanupWorkspaceVolume() {
+	if !d.wsFromDockerVolume || !d.wsCleanup.HasAction() {
+		return
+	}
+
+	script := workspaceCleanupScript(dockerWorkspace, util.ParseString(d.inCmd.FlowId), d.wsCleanup)
+	exitCode, output, err := d.runSingleScriptWithOutput(script)
+	if err != nil || exitCode != 0 {
+		util.LogWarn("Unable to cleanup workspace volume, exit code %d", exitCode)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if util.HasString(line) {
+			d.writeSingleLog(line)
+		}
+	}
+
+	// apply once for all attempts
+	d.wsCleanup = nil
+}
+
+// prepareJobDirInVolume create job scoped dir in the volume and hold it, reuse last successful job dir if it's required
+func (d *dockerExecutor) prepareJobDirInVolume() {
+	if !d.wsFromDockerVolume || !domain.IsJobScopedDir(d.jobDirMode) {
+		return
+	}
+
+	reuse := ""
+	if d.isReuseJobDir() {
+		reuse = d.jobDirMode
+	}
+
+	lease := d.timeout + d.inCmd.GetGracePeriod() + jobDirIdleTimeout
+	script := jobDirScript(dockerWorkspace, d.jobDirName(), d.jobDirHolder(), d.inCmd.ID, lease, reuse)
+	exitCode, output, err := d.runSingleScriptWithOutput(script)
+	if err != nil || exitCode != 0 {
+		util.LogWarn("Unable to prepare job dir in volume, exit code %d", exitCode)
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if util.HasString(line) {
+			d.writeSingleLog(line)
+		}
+	}
+}
+
+// releaseJobDirInVolume renew the lease of job dir in volume and record the step failure, run before container cleanup
+func (d *dockerExecutor) releaseJobDirInVolume() {
+	if !d.wsFromDockerVolume || !domain.IsJobScopedDir(d.jobDirMode) {
+		return
+	}
+
+	if d.runtime() == nil || util.IsEmptyString(d.runtime().ContainerID) {
+		return
+	}
+
+	script := releaseJobDirScript(dockerWorkspace, d.jobDirName(), d.jobDirHolder(), d.inCmd.ID, d.isStepFailed())
+	if exitCode, err := d.runSingleScript(script); err != nil || exitCode != 0 {
+		util.LogWarn("Unable to release job dir in volume, exit code %d", exitCode)
+	}
+}
+
+// copyCheckout copy the checkout from agent to job dir in volume
+func (d *dockerExecutor) copyCheckout() {
+	if util.IsEmptyString(d.checkoutOnHost) {
+		return
+	}
+
+	reader, err := tarArchiveFromPathAs(d.checkoutOnHost, path.Base(d.jobDir))
+	util.PanicIfErr(err)
+
+	config := types.CopyToContainerOptions{
+		AllowOverwriteDirWithFile: true,
+	}
+
+	err = d.cli.CopyToContainer(d.context, d.runtime().ContainerID, path.Dir(d.jobDir), reader, config)
+	util.PanicIfErr(err)
+	util.LogDebug("Checkout been copied to %s in container", d.jobDir)
+}
+
 // copy plugin to docker container from real plugin dir
+
 func (d *dockerExecutor) copyPlugins() {
 	config := types.CopyToContainerOptions{
 		AllowOverwriteDirWithFile: true,
@@ -596,6 +949,45 @@ func (d *dockerExecutor) copyCache() {
 	}
 }
 
+// hashFilesInContainer sha256 of files that matched patterns in job dir of container
+func (d *dockerExecutor) hashFilesInContainer(patterns []string) (string, error) {
+	exitCode, output, err := d.runSingleScriptWithOutput(fmt.Sprintf("cd '%s' && find . -type f", d.jobDir))
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 {
+		return "", fmt.Errorf("unable to list files in %s: %s", d.jobDir, output)
+	}
+
+	var files []string
+	for _, line := range strings.Split(output, "\n") {
+		file := strings.TrimPrefix(strings.TrimSpace(line), "./")
+		if util.HasString(file) && util.MatchAnyPath(patterns, file) {
+			files = append(files, file)
+		}
+	}
+
+	sort.Strings(files)
+
+	sums := make([]string, len(files))
+	for i, file := range files {
+		reader, _, err := d.cli.CopyFromContainer(d.context, d.runtime().ContainerID, d.jobDir+util.UnixPathSeparator+file)
+		if err != nil {
+			return "", err
+		}
+
+		sums[i], err = sha256OfTarEntry(reader)
+		reader.Close()
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return util.HashOfSums(sums), nil
+}
+
 func (d *dockerExecutor) writeCache() {
 	if !d.inCmd.HasCache() {
 		return
@@ -628,7 +1020,8 @@ func (d *dockerExecutor) writeCache() {
 	}
 }
 
-func (d *dockerExecutor) runShell() string {
+// runShell start the shell and return exec id with channel that closed on output stream ended
+func (d *dockerExecutor) runShell() (string, <-chan struct{}) {
 	runtime := d.runtime()
 
 	config := types.ExecConfig{
@@ -642,7 +1035,7 @@ func (d *dockerExecutor) runShell() string {
 	exec, err := d.cli.ContainerExecCreate(d.context, runtime.ContainerID, config)
 	util.PanicIfErr(err)
 
-	attach, err := d.cli.ContainerExecAttach(d.context, exec.ID, types.ExecConfig{Tty: false})
+	attach, err := d.cli.ContainerExecAttach(d.context, exec.ID, types.ExecStartCheck{Tty: false})
 	util.PanicIfErr(err)
 
 	setupContainerIpAndBin := func() []string {
@@ -650,14 +1043,7 @@ func (d *dockerExecutor) runShell() string {
 
 		for i, c := range d.configs {
 			inspect, _ := d.cli.ContainerInspect(d.context, c.ContainerID)
-			address := inspect.NetworkSettings.IPAddress
-
-			if address == "" && len(inspect.NetworkSettings.Networks) > 0 {
-				for _, v := range inspect.NetworkSettings.Networks {
-					address += v.IPAddress + ","
-				}
-				address = address[:len(address)-1]
-			}
+			address := strings.Join(containerAddresses(inspect), ",")
 
 			scripts = append(scripts, fmt.Sprintf(domain.VarExportContainerIdPattern, i, c.ContainerID))
 			scripts = append(scripts, fmt.Sprintf(domain.VarExportContainerIpPattern, i, address))
@@ -687,14 +1073,24 @@ func (d *dockerExecutor) runShell() string {
 
 	_, _ = attach.Conn.Write([]byte(writeShellPid))
 
-	d.writeLog(attach.Reader, true, true)
-	d.writeCmd(attach.Conn, setupContainerIpAndBin, writeEnvAfter, doScript)
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		d.writeLog(attach.Reader, false, true)
+	}()
 
-	return exec.ID
+	d.writeCmd(attach.Conn, setupContainerIpAndBin, writeEnvAfter, doScript)
+	return exec.ID, streamDone
 }
 
 // run single bash script with new context
 func (d *dockerExecutor) runSingleScript(script string) (exitCode int, err error) {
+	exitCode, _, err = d.runSingleScriptWithOutput(script)
+	return
+}
+
+// run single bash script with new context and return stdout and stderr
+func (d *dockerExecutor) runSingleScriptWithOutput(script string) (exitCode int, output string, err error) {
 	defer util.RecoverPanic(func(e error) {
 		exitCode = dockerDefaultExitCode
 	})
@@ -702,15 +1098,32 @@ func (d *dockerExecutor) runSingleScript(script string) (exitCode int, err error
 	ctx := context.Background()
 
 	exec, err := d.cli.ContainerExecCreate(ctx, d.runtime().ContainerID, types.ExecConfig{
-		Cmd: []string{linuxBash, "-c", script},
+		Cmd:          []string{linuxBash, "-c", script},
+		AttachStdout: true,
+		AttachStderr: true,
 	})
 	util.PanicIfErr(err)
 
 	util.LogDebug("Script: %s will run", script)
-	err = d.cli.ContainerExecStart(ctx, exec.ID, types.ExecStartCheck{Detach: true, Tty: false})
+	attach, err := d.cli.ContainerExecAttach(ctx, exec.ID, types.ExecStartCheck{Tty: false})
 	util.PanicIfErr(err)
+	defer attach.Close()
+
+	// the stream ended when script exited
+	var buf bytes.Buffer
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		_, _ = stdcopy.StdCopy(&buf, &buf, attach.Reader)
+	}()
 
-	exitCode = d.waitForExit(ctx, exec.ID, nil)
+	exitCode = d.waitForExit(ctx, exec.ID, streamDone, nil)
+
+	// close the stream which might be held by background processes before reading the output
+	_ = attach.Conn.Close()
+	<-streamDone
+
+	output = buf.String()
 	return
 }
 
@@ -750,25 +1163,56 @@ func (d *dockerExecutor) cleanupContainer() {
 	}
 }
 
-func (d *dockerExecutor) waitForExit(ctx context.Context, eid string, onStarted func(int)) int {
-	inspect, err := d.cli.ContainerExecInspect(ctx, eid)
-	util.PanicIfErr(err)
+// waitForExit wait for the attached stream ended and get exit code of exec, the exec is inspected on a slow tick as well,
+// since the stream is held by background processes until they exited, ex: 'server &'
+func (d *dockerExecutor) waitForExit(ctx context.Context, eid string, streamDone <-chan struct{}, onStarted func(int)) int {
 	if onStarted != nil {
+		inspect, err := d.cli.ContainerExecInspect(ctx, eid)
+		util.PanicIfErr(err)
 		onStarted(inspect.Pid)
 	}
 
+	ticker := time.NewTicker(dockerExecInspectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-streamDone:
+			return d.waitForExitCode(ctx, eid)
+		case <-ticker.C:
+			inspect, err := d.cli.ContainerExecInspect(ctx, eid)
+			util.PanicIfErr(err)
+
+			if !inspect.Running {
+				return inspect.ExitCode
+			}
+		case <-ctx.Done():
+			panic(ctx.Err())
+		}
+	}
+}
+
+// waitForExitCode inspect exec with backoff, since the exit code might not be set right after stream closed
+func (d *dockerExecutor) waitForExitCode(ctx context.Context, eid string) int {
+	delay := dockerExecInspectMinDelay
 	for {
-		inspect, err = d.cli.ContainerExecInspect(ctx, eid)
+		inspect, err := d.cli.ContainerExecInspect(ctx, eid)
 		util.PanicIfErr(err)
 
 		if !inspect.Running {
-			break
+			return inspect.ExitCode
 		}
 
-		time.Sleep(1 * time.Second)
-	}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			panic(ctx.Err())
+		}
 
-	return inspect.ExitCode
+		if delay < dockerExecInspectMaxDelay {
+			delay *= 2
+		}
+	}
 }
 
 func (d *dockerExecutor) getVolume(name string) (bool, *types.Volume) {
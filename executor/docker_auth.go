@@ -0,0 +1,235 @@
+package executor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+)
+
+const (
+	dockerHubRegistry       = "docker.io"
+	dockerHubIndexServer    = "https://index.docker.io/v1/"
+	dockerCredHelperPrefix  = "docker-credential-"
+	dockerCredTokenUsername = "<token>"
+)
+
+type (
+	// dockerConfigFile the agent's docker config.json, only auth related fields
+	dockerConfigFile struct {
+		Auths       map[string]dockerConfigAuth `json:"auths"`
+		CredsStore  string                      `json:"credsStore"`
+		CredHelpers map[string]string           `json:"credHelpers"`
+	}
+
+	dockerConfigAuth struct {
+		Auth          string `json:"auth"` // base64 of username:password
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	}
+
+	// dockerCredHelperOut the output of 'docker-credential-<helper> get'
+	dockerCredHelperOut struct {
+		ServerURL string
+		Username  string
+		Secret    string
+	}
+)
+
+var (
+	// dockerCredHelperRunner run credential helper with registry host as stdin, replaceable in test
+	dockerCredHelperRunner = func(helper, host string) ([]byte, error) {
+		cmd := exec.Command(dockerCredHelperPrefix+helper, "get")
+		cmd.Stdin = strings.NewReader(host)
+		return cmd.Output()
+	}
+)
+
+// encodeRegistryAuth encode auth config to base64 json for ImagePull
+func encodeRegistryAuth(auth *types.AuthConfig) (string, error) {
+	jsonBytes, err := json.Marshal(auth)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(jsonBytes), nil
+}
+
+// resolveRegistryAuth use explicit auth pair from flow secret, otherwise the agent's docker config
+func resolveRegistryAuth(image string, pair *domain.SimpleAuthPair) *types.AuthConfig {
+	if pair != nil {
+		return &types.AuthConfig{
+			Username: pair.Username,
+			Password: pair.Password,
+		}
+	}
+
+	config, err := loadDockerConfigFile(dockerConfigPath())
+	if err != nil {
+		util.LogWarn("Unable to load docker config: %s", err.Error())
+		return nil
+	}
+
+	if config == nil {
+		return nil
+	}
+
+	auth, err := config.authOf(registryHost(image))
+	if err != nil {
+		util.LogWarn("Unable to get credential of image %s: %s", image, err.Error())
+		return nil
+	}
+
+	return auth
+}
+
+// dockerConfigPath get config.json from $DOCKER_CONFIG or ~/.docker
+func dockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); util.HasString(dir) {
+		return filepath.Join(dir, "config.json")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// loadDockerConfigFile return nil if config file not existed
+func loadDockerConfigFile(path string) (*dockerConfigFile, error) {
+	if util.IsEmptyString(path) || !util.IsFileExists(path) {
+		return nil, nil
+	}
+
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &dockerConfigFile{}
+	if err = json.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("invalid docker config %s: %s", path, err.Error())
+	}
+
+	return config, nil
+}
+
+// authOf find credential by registry host, the credHelpers has the highest priority, then credsStore and auths
+func (c *dockerConfigFile) authOf(host string) (*types.AuthConfig, error) {
+	for key, helper := range c.CredHelpers {
+		if normalizeRegistryHost(key) == host {
+			return getAuthFromCredHelper(helper, key)
+		}
+	}
+
+	if util.HasString(c.CredsStore) {
+		return getAuthFromCredHelper(c.CredsStore, serverAddressOf(host))
+	}
+
+	for key, auth := range c.Auths {
+		if normalizeRegistryHost(key) != host {
+			continue
+		}
+
+		config := &types.AuthConfig{
+			Username:      auth.Username,
+			Password:      auth.Password,
+			IdentityToken: auth.IdentityToken,
+			ServerAddress: key,
+		}
+
+		if util.HasString(auth.Auth) {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			if err != nil {
+				return nil, err
+			}
+
+			items := strings.SplitN(string(decoded), ":", 2)
+			if len(items) != 2 {
+				return nil, fmt.Errorf("invalid auth of registry %s", key)
+			}
+
+			config.Username = items[0]
+			config.Password = items[1]
+		}
+
+		return config, nil
+	}
+
+	return nil, nil
+}
+
+func getAuthFromCredHelper(helper, host string) (*types.AuthConfig, error) {
+	raw, err := dockerCredHelperRunner(helper, host)
+	if err != nil {
+		// credentials not found in helper
+		if bytes.Contains(raw, []byte("credentials not found")) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%s%s: %s", dockerCredHelperPrefix, helper, err.Error())
+	}
+
+	out := &dockerCredHelperOut{}
+	if err = json.Unmarshal(raw, out); err != nil {
+		return nil, err
+	}
+
+	if out.Username == dockerCredTokenUsername {
+		return &types.AuthConfig{IdentityToken: out.Secret, ServerAddress: host}, nil
+	}
+
+	return &types.AuthConfig{
+		Username:      out.Username,
+		Password:      out.Secret,
+		ServerAddress: host,
+	}, nil
+}
+
+// registryHost get registry host from image, ex: gcr.io/project/app:1.0 -> gcr.io, ubuntu -> docker.io
+func registryHost(image string) string {
+	index := strings.Index(image, "/")
+	if index == -1 {
+		return dockerHubRegistry
+	}
+
+	first := image[:index]
+	if strings.ContainsAny(first, ".:") || first == "localhost" {
+		return normalizeRegistryHost(first)
+	}
+
+	return dockerHubRegistry
+}
+
+// normalizeRegistryHost remove scheme and path from the key of auths, and map docker hub aliases to docker.io
+func normalizeRegistryHost(key string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://")
+	if index := strings.Index(host, "/"); index != -1 {
+		host = host[:index]
+	}
+
+	switch host {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHubRegistry
+	default:
+		return host
+	}
+}
+
+// serverAddressOf get the server address that stored in the creds store
+func serverAddressOf(host string) string {
+	if host == dockerHubRegistry {
+		return dockerHubIndexServer
+	}
+	return host
+}
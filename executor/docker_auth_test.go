@@ -0,0 +1,73 @@
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldGetRegistryHostFromImage(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("docker.io", registryHost("ubuntu:18.04"))
+	assert.Equal("docker.io", registryHost("flowci/agent"))
+	assert.Equal("gcr.io", registryHost("gcr.io/project/app:1.0"))
+	assert.Equal("localhost:5000", registryHost("localhost:5000/ubuntu"))
+	assert.Equal("docker.io", normalizeRegistryHost("https://index.docker.io/v1/"))
+}
+
+func TestShouldResolveRegistryAuthFromDockerConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "docker_config_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	config := `{
+  "auths": {
+    "https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNz"},
+    "localhost:5000": {"username": "local", "password": "12345"}
+  },
+  "credHelpers": {
+    "123.dkr.ecr.us-east-1.amazonaws.com": "ecr-login"
+  }
+}`
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0644))
+	assert.NoError(os.Setenv("DOCKER_CONFIG", dir))
+	defer os.Unsetenv("DOCKER_CONFIG")
+
+	runner := dockerCredHelperRunner
+	defer func() { dockerCredHelperRunner = runner }()
+
+	dockerCredHelperRunner = func(helper, host string) ([]byte, error) {
+		if helper != "ecr-login" {
+			return nil, fmt.Errorf("unexpected helper %s", helper)
+		}
+		return []byte(`{"ServerURL":"` + host + `","Username":"AWS","Secret":"token"}`), nil
+	}
+
+	auth := resolveRegistryAuth("ubuntu:18.04", nil)
+	assert.NotNil(auth)
+	assert.Equal("user", auth.Username)
+	assert.Equal("pass", auth.Password)
+
+	auth = resolveRegistryAuth("localhost:5000/ubuntu", nil)
+	assert.NotNil(auth)
+	assert.Equal("local", auth.Username)
+
+	auth = resolveRegistryAuth("123.dkr.ecr.us-east-1.amazonaws.com/app:1.0", nil)
+	assert.NotNil(auth)
+	assert.Equal("AWS", auth.Username)
+	assert.Equal("token", auth.Password)
+
+	assert.Nil(resolveRegistryAuth("gcr.io/project/app", nil))
+
+	// explicit auth from secret has the highest priority
+	auth = resolveRegistryAuth("ubuntu", &domain.SimpleAuthPair{Username: "secret", Password: "pass"})
+	assert.Equal("secret", auth.Username)
+}
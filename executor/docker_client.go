@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/versions"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/tlsconfig"
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+)
+
+// DockerClient docker api client created on the docker host settings
+type DockerClient struct {
+	*client.Client
+}
+
+// NewDockerClient create docker client from docker host settings, and check the api version with daemon
+func NewDockerClient(ctx context.Context, host *domain.DockerHost) (*DockerClient, error) {
+	if host == nil {
+		host = &domain.DockerHost{}
+	}
+
+	if err := host.Validate(); err != nil {
+		return nil, err
+	}
+
+	transport := new(http.Transport)
+
+	if host.IsTLS() {
+		tlsc, err := tlsconfig.Client(tlsconfig.Options{
+			CAFile:             filepath.Join(host.GetCertPath(), "ca.pem"),
+			CertFile:           filepath.Join(host.GetCertPath(), "cert.pem"),
+			KeyFile:            filepath.Join(host.GetCertPath(), "key.pem"),
+			InsecureSkipVerify: !host.IsTLSVerify(),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		transport.TLSClientConfig = tlsc
+	}
+
+	// the host has to be applied after http client, so the transport is configured for it
+	options := []client.Opt{
+		client.WithHTTPClient(&http.Client{Transport: transport}),
+		client.WithHost(host.ResolveHost()),
+	}
+
+	if util.HasString(host.APIVersion) {
+		options = append(options, client.WithVersion(host.APIVersion))
+	}
+
+	cli, err := client.NewClientWithOpts(options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = negotiateApiVersion(ctx, cli, host.ResolveHost(), util.HasString(host.APIVersion)); err != nil {
+		return nil, err
+	}
+
+	return &DockerClient{Client: cli}, nil
+}
+
+// negotiateApiVersion downgrade client api version to daemon's if it's not fixed,
+// return error if the api version is not supported by daemon
+func negotiateApiVersion(ctx context.Context, cli *client.Client, host string, isFixed bool) error {
+	server, err := cli.ServerVersion(ctx)
+	if err != nil {
+		return fmt.Errorf("unable to connect docker daemon %s: %s", host, err.Error())
+	}
+
+	version := cli.ClientVersion()
+	if versions.LessThan(server.APIVersion, version) {
+		if isFixed {
+			return fmt.Errorf("docker api version mismatch, client %s is newer than daemon %s (%s)", version, server.APIVersion, server.Version)
+		}
+
+		cli.NegotiateAPIVersionPing(types.Ping{APIVersion: server.APIVersion})
+		version = cli.ClientVersion()
+	}
+
+	if server.MinAPIVersion != "" && versions.LessThan(version, server.MinAPIVersion) {
+		return fmt.Errorf("docker api version mismatch, client %s is older than min version %s of daemon %s", version, server.MinAPIVersion, server.Version)
+	}
+
+	util.LogDebug("Docker daemon %s (api %s), client api %s", server.Version, server.APIVersion, version)
+	return nil
+}
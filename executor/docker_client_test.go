@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldCreateContainerWithStartPeriodAndPullByPlatform(t *testing.T) {
+	assert := assert.New(t)
+
+	var created map[string]interface{}
+	var platform string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/version"):
+			_, _ = w.Write([]byte(`{"Version": "20.10.0", "ApiVersion": "1.40", "MinAPIVersion": "1.12"}`))
+		case strings.HasSuffix(r.URL.Path, "/containers/create"):
+			assert.NoError(json.NewDecoder(r.Body).Decode(&created))
+			_, _ = w.Write([]byte(`{"Id": "c1"}`))
+		case strings.HasSuffix(r.URL.Path, "/images/create"):
+			platform = r.URL.Query().Get("platform")
+			_, _ = w.Write([]byte(`{"status": "Pulled"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"message": "not found"}`))
+		}
+	}))
+	defer server.Close()
+
+	host := "tcp://" + strings.TrimPrefix(server.URL, "http://")
+	cli, err := NewDockerClient(context.Background(), &domain.DockerHost{Host: host, APIVersion: "1.40"})
+	assert.NoError(err)
+
+	hc := &domain.DockerHealthCheck{Command: []string{"mysqladmin ping"}, Retries: 3, StartPeriod: 30}
+	config := &container.Config{
+		Image:       "mysql:5.7",
+		Healthcheck: hc.ToConfig(),
+	}
+
+	resp, err := cli.ContainerCreate(context.Background(), config, &container.HostConfig{}, nil, nil, "mysql")
+	assert.NoError(err)
+	assert.Equal("c1", resp.ID)
+
+	healthcheck := created["Healthcheck"].(map[string]interface{})
+	assert.Equal(float64(30*time.Second), healthcheck["StartPeriod"])
+	assert.Equal(float64(3), healthcheck["Retries"])
+	assert.Equal("mysql:5.7", created["Image"])
+
+	reader, err := cli.ImagePull(context.Background(), "docker.io/library/ubuntu:18.04", types.ImagePullOptions{Platform: "linux/arm64/v8"})
+	assert.NoError(err)
+	content, _ := ioutil.ReadAll(reader)
+	_ = reader.Close()
+	assert.Contains(string(content), "Pulled")
+	assert.Equal("linux/arm64/v8", platform)
+
+	// should downgrade to daemon api version if it's not fixed
+	cli, err = NewDockerClient(context.Background(), &domain.DockerHost{Host: host})
+	assert.NoError(err)
+	assert.Equal("1.40", cli.ClientVersion())
+
+	// should fail if fixed version is newer than daemon
+	_, err = NewDockerClient(context.Background(), &domain.DockerHost{Host: host, APIVersion: "1.41"})
+	assert.Error(err)
+}
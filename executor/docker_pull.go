@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+const (
+	dockerPullLogInterval = 2 * time.Second
+)
+
+var (
+	// dockerPullPermanentErrors the pull errors that will not be recovered by retry
+	dockerPullPermanentErrors = []string{
+		"manifest unknown",
+		"repository does not exist",
+		"pull access denied",
+	}
+)
+
+type (
+	// pullMessage the json message from docker image pull stream
+	pullMessage struct {
+		ID             string `json:"id"`
+		Status         string `json:"status"`
+		ProgressDetail struct {
+			Current int64 `json:"current"`
+			Total   int64 `json:"total"`
+		} `json:"progressDetail"`
+		Error       string `json:"error"`
+		ErrorDetail *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errorDetail"`
+	}
+
+	// pullProgress decode docker image pull stream to readable log lines
+	pullProgress struct {
+		write    func(string)
+		interval time.Duration
+		sizes    map[string]int64     // layer id -> total size
+		status   map[string]string    // layer id -> last logged status
+		logAt    map[string]time.Time // layer id -> last logged time of progress
+	}
+)
+
+func newPullProgress(write func(string)) *pullProgress {
+	return &pullProgress{
+		write:    write,
+		interval: dockerPullLogInterval,
+		sizes:    make(map[string]int64),
+		status:   make(map[string]string),
+		logAt:    make(map[string]time.Time),
+	}
+}
+
+// consume read all messages from pull stream, return error if stream contains error
+func (p *pullProgress) consume(image string, reader io.Reader) error {
+	start := time.Now()
+	decoder := json.NewDecoder(reader)
+
+	for {
+		var msg pullMessage
+		if err := decoder.Decode(&msg); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		if msg.ErrorDetail != nil && msg.ErrorDetail.Message != "" {
+			return fmt.Errorf("unable to pull image %s: %s", image, msg.ErrorDetail.Message)
+		}
+
+		if msg.Error != "" {
+			return fmt.Errorf("unable to pull image %s: %s", image, msg.Error)
+		}
+
+		p.onMessage(&msg)
+	}
+
+	var total int64
+	for _, size := range p.sizes {
+		total += size
+	}
+
+	p.write(fmt.Sprintf("Image %s pulled, %d layers, %s in %s",
+		image, len(p.sizes), units.HumanSize(float64(total)), time.Since(start).Round(time.Millisecond)))
+	return nil
+}
+
+func (p *pullProgress) onMessage(msg *pullMessage) {
+	if strings.TrimSpace(msg.Status) == "" {
+		return
+	}
+
+	if msg.ID == "" {
+		p.write(msg.Status)
+		return
+	}
+
+	current, total := msg.ProgressDetail.Current, msg.ProgressDetail.Total
+	if msg.Status == "Downloading" && total > 0 {
+		p.sizes[msg.ID] = total
+	}
+
+	// progress message, ex: Downloading or Extracting
+	if total > 0 {
+		if msg.Status == p.status[msg.ID] && time.Since(p.logAt[msg.ID]) < p.interval {
+			return
+		}
+
+		p.status[msg.ID] = msg.Status
+		p.logAt[msg.ID] = time.Now()
+		p.write(fmt.Sprintf("%s: %s %s/%s", msg.ID, msg.Status,
+			units.HumanSize(float64(current)), units.HumanSize(float64(total))))
+		return
+	}
+
+	if msg.Status == p.status[msg.ID] {
+		return
+	}
+
+	p.status[msg.ID] = msg.Status
+	p.write(fmt.Sprintf("%s: %s", msg.ID, msg.Status))
+}
+
+// isPermanentPullError the image not exist or no permission, only the transport or transient errors are retried
+func isPermanentPullError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, pattern := range dockerPullPermanentErrors {
+		if strings.Contains(msg, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDecodeDockerPullProgress(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := `{"status":"Pulling from library/ubuntu","id":"18.04"}
+{"status":"Pulling fs layer","progressDetail":{},"id":"a1"}
+{"status":"Downloading","progressDetail":{"current":1024,"total":2048},"id":"a1"}
+{"status":"Downloading","progressDetail":{"current":2000,"total":2048},"id":"a1"}
+{"status":"Download complete","progressDetail":{},"id":"a1"}
+{"status":"Pull complete","progressDetail":{},"id":"a1"}
+{"status":""}
+{"status":"Digest: sha256:abc"}`
+
+	var lines []string
+	p := newPullProgress(func(line string) {
+		lines = append(lines, line)
+	})
+	p.interval = time.Hour
+
+	err := p.consume("ubuntu:18.04", strings.NewReader(stream))
+	assert.NoError(err)
+
+	assert.Equal(7, len(lines))
+	assert.Equal("a1: Downloading 1.024kB/2.048kB", lines[2])
+	assert.Equal("a1: Download complete", lines[3])
+	assert.Equal("Digest: sha256:abc", lines[5])
+	assert.True(strings.HasPrefix(lines[6], "Image ubuntu:18.04 pulled, 1 layers, 2.048kB in"))
+}
+
+func TestShouldReturnErrorFromDockerPullStream(t *testing.T) {
+	assert := assert.New(t)
+
+	stream := `{"status":"Pulling from library/ubuntu","id":"404"}
+{"errorDetail":{"message":"manifest for ubuntu:404 not found"},"error":"manifest for ubuntu:404 not found"}`
+
+	err := newPullProgress(func(string) {}).consume("ubuntu:404", strings.NewReader(stream))
+	assert.Error(err)
+	assert.Equal("unable to pull image ubuntu:404: manifest for ubuntu:404 not found", err.Error())
+}
+
+func TestShouldRetryTransientPullErrorOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isPermanentPullError(fmt.Errorf("unable to pull image ubuntu:404: manifest for ubuntu:404 not found: manifest unknown: manifest unknown")))
+	assert.True(isPermanentPullError(fmt.Errorf("Error response from daemon: pull access denied for abc, repository does not exist")))
+
+	assert.False(isPermanentPullError(fmt.Errorf("Get https://registry/v2/: proxy: 404 page not found")))
+	assert.False(isPermanentPullError(fmt.Errorf("Get https://registry/v2/: dial tcp: lookup registry: no such host, not found")))
+
+	assert.False(isPermanentPullError(fmt.Errorf("net/http: TLS handshake timeout")))
+	assert.False(isPermanentPullError(fmt.Errorf("read tcp 10.0.0.1:443: connection reset by peer")))
+	assert.False(isPermanentPullError(fmt.Errorf("unexpected EOF")))
+}
@@ -176,6 +176,86 @@ func TestShouldRunWithTwoContainers(t *testing.T) {
 	assert.Equal(3, len(r.Containers))
 }
 
+func TestShouldWaitForHealthyServiceContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createDockerTestCmd()
+	cmd.Dockers = append(cmd.Dockers, &domain.DockerOption{
+		Image: "mysql:5.6",
+		Environment: map[string]string{
+			"MYSQL_ROOT_PASSWORD": "test",
+		},
+		HealthCheck: &domain.DockerHealthCheck{
+			Command:  []string{"mysqladmin ping -h127.0.0.1 -uroot -ptest"},
+			Interval: 1,
+		},
+		IsDeleteContainer: true,
+	})
+	cmd.Dockers = append(cmd.Dockers, &domain.DockerOption{
+		Image: "mysql:5.6",
+		Environment: map[string]string{
+			"MYSQL_ROOT_PASSWORD": "test",
+		},
+		HealthCheck: &domain.DockerHealthCheck{
+			Port:     3306,
+			Interval: 1,
+		},
+		IsDeleteContainer: true,
+	})
+
+	executor := newExecutor(cmd, false)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.Stdout())
+	assert.NoError(executor.Start())
+
+	r := executor.GetResult()
+	assert.Equal(domain.CmdStatusSuccess, r.Status)
+	assert.Equal(3, len(r.Containers))
+}
+
+func TestShouldRunSingleScriptWithoutPolling(t *testing.T) {
+	assert := assert.New(t)
+
+	executor := newExecutor(createDockerTestCmd(), false)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.Stdout())
+
+	dockerExecutor := executor.(*dockerExecutor)
+	dockerExecutor.pullImage()
+	dockerExecutor.startContainer()
+	defer dockerExecutor.cleanupContainer()
+
+	// should be done without 1 second polling interval of exec inspect
+	start := time.Now()
+	exitCode, err := dockerExecutor.runSingleScript("exit 3")
+	assert.NoError(err)
+	assert.Equal(3, exitCode)
+	assert.True(time.Since(start) < time.Second)
+}
+
+func TestShouldExitWhenBackgroundProcessHoldStream(t *testing.T) {
+	assert := assert.New(t)
+
+	executor := newExecutor(createDockerTestCmd(), false)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.Stdout())
+
+	dockerExecutor := executor.(*dockerExecutor)
+	dockerExecutor.pullImage()
+	dockerExecutor.startContainer()
+	defer dockerExecutor.cleanupContainer()
+
+	// should be done by inspect tick, since the stream is held by sleep in background
+	start := time.Now()
+	exitCode, err := dockerExecutor.runSingleScript("sleep 60 & exit 2")
+	assert.NoError(err)
+	assert.Equal(2, exitCode)
+	assert.True(time.Since(start) < 2*dockerExecInspectInterval)
+}
+
 func createDockerTestCmd() *domain.ShellIn {
 	return &domain.ShellIn{
 		CmdIn: domain.CmdIn{
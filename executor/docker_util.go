@@ -4,11 +4,19 @@ import (
 	"archive/tar"
 	"bufio"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/flowci/flow-agent-x/domain"
 	"github.com/flowci/flow-agent-x/util"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
 const (
@@ -30,6 +38,116 @@ func isDockerHubImage(image string) bool {
 	return false
 }
 
+func killScript(pidFile string, grace time.Duration) string {
+	return fmt.Sprintf(killScriptPattern, pidFile, int(grace.Seconds()))
+}
+
+func workspaceCleanupScript(ws, current string, cleanup *domain.WorkspaceCleanup) string {
+	keep, min := 0, int64(0)
+	if cleanup.Policy != nil {
+		keep, min = cleanup.Policy.KeepFlows, cleanup.Policy.MinFreeDisk
+	}
+
+	quoted := make([]string, len(cleanup.CleanFlows))
+	for i, flow := range cleanup.CleanFlows {
+		quoted[i] = quoteScriptArg(flow)
+	}
+
+	return fmt.Sprintf(workspaceCleanupPattern, ws, quoteScriptArg(current), keep, min, strings.Join(quoted, " "))
+}
+
+// jobDirScript get script to prepare job dir in container, the jobDir is '<flow id>/<job id>' relative to ws
+func jobDirScript(ws, jobDir, holder, step string, lease time.Duration, reuse string) string {
+	flow, job := path.Split(jobDir)
+	return fmt.Sprintf(jobDirScriptPattern, quoteScriptArg(ws+"/"+path.Clean(flow)), quoteScriptArg(job),
+		quoteScriptArg(holder), quoteScriptArg(step), int64(lease.Seconds()), jobDirRetention, quoteScriptArg(reuse))
+}
+
+// releaseJobDirScript get script to renew the lease of job dir and record the step failure
+func releaseJobDirScript(ws, jobDir, holder, step string, failed bool) string {
+	flow, job := path.Split(jobDir)
+	return fmt.Sprintf(releaseJobDirScriptPattern, quoteScriptArg(ws+"/"+path.Clean(flow)), quoteScriptArg(job),
+		quoteScriptArg(holder), quoteScriptArg(step), int64(jobDirIdleTimeout.Seconds()), failed)
+}
+
+func quoteScriptArg(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", "") + "'"
+}
+
+// verifyImagePlatform check image os/arch/variant from raw inspect is matched with expected platform os/arch[/variant]
+func verifyImagePlatform(raw []byte, image, platform string) error {
+	if platform == "" {
+		return nil
+	}
+
+	inspect := struct {
+		Os           string
+		Architecture string
+		Variant      string
+	}{}
+
+	if err := json.Unmarshal(raw, &inspect); err != nil {
+		return err
+	}
+
+	actual := inspect.Os + "/" + inspect.Architecture
+	if util.HasString(inspect.Variant) {
+		actual += "/" + inspect.Variant
+	}
+
+	items := strings.Split(platform, "/")
+	if items[0] != inspect.Os || items[1] != inspect.Architecture || (len(items) > 2 && items[2] != inspect.Variant) {
+		return fmt.Errorf("docker '%s': invalid option 'platform' = '%s', the image platform is '%s'", image, platform, actual)
+	}
+
+	return nil
+}
+
+// resolveImageDigest get repo digest ref of the image, or image id if no digest of the image repository
+func resolveImageDigest(inspect types.ImageInspect, image string) string {
+	repo := imageRepository(image)
+
+	for _, digest := range inspect.RepoDigests {
+		if imageRepository(digest) == repo {
+			return digest
+		}
+	}
+
+	// the digests of other repositories are not the image pulled
+	return inspect.ID
+}
+
+// imageRepository remove tag and digest from image ref, ex: localhost:5000/ubuntu:18.04 -> localhost:5000/ubuntu
+func imageRepository(image string) string {
+	if index := strings.Index(image, "@"); index != -1 {
+		image = image[:index]
+	}
+
+	if index := strings.LastIndex(image, ":"); index > strings.LastIndex(image, "/") {
+		image = image[:index]
+	}
+
+	return image
+}
+
+// get ip addresses of the container from bridge or user defined networks
+func containerAddresses(inspect types.ContainerJSON) []string {
+	if inspect.NetworkSettings == nil {
+		return nil
+	}
+
+	if inspect.NetworkSettings.IPAddress != "" {
+		return []string{inspect.NetworkSettings.IPAddress}
+	}
+
+	var addresses []string
+	for _, v := range inspect.NetworkSettings.Networks {
+		addresses = append(addresses, v.IPAddress)
+	}
+
+	return addresses
+}
+
 func removeDockerHeader(in []byte) []byte {
 	if len(in) < dockerHeaderSize {
 		return in
@@ -46,48 +164,43 @@ func removeDockerHeader(in []byte) []byte {
 	return in
 }
 
+// untarFromReader extract tar stream to dest with permissions, symlinks and mtimes
 func untarFromReader(tarReader io.Reader, dest string) error {
-	reader := tar.NewReader(tarReader)
+	return util.Untar(tarReader, dest)
+}
+
+// sha256OfTarEntry sha256 of the first regular file in tar stream
+func sha256OfTarEntry(r io.Reader) (string, error) {
+	reader := tar.NewReader(r)
+
 	for {
 		header, err := reader.Next()
-		if err == io.EOF {
-			break
-		}
-
 		if err != nil {
-			return err
+			return "", err
 		}
 
-		fileInfo := header.FileInfo()
-		target := dest + util.UnixPathSeparator + header.Name
-
-		if fileInfo.IsDir() {
-			if err := os.MkdirAll(target, 0755); err != nil {
-				return err
-			}
+		if header.Typeflag != tar.TypeReg && header.Typeflag != tar.TypeRegA {
 			continue
 		}
 
-		f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-		if err != nil {
-			return err
+		hash := sha256.New()
+		if _, err = io.Copy(hash, reader); err != nil {
+			return "", err
 		}
 
-		if _, err := io.Copy(f, reader); err != nil {
-			return err
-		}
-
-		f.Close()
+		return hex.EncodeToString(hash.Sum(nil)), nil
 	}
-
-	return nil
 }
 
 // tar dir, ex: abc/.. output is archived content .. in dir
 func tarArchiveFromPath(path string) (io.Reader, error) {
+	return tarArchiveFromPathAs(path, filepath.Base(path))
+}
+
+// tar dir with root name in the archive, ex: abc/.. output is archived content .. in root dir
+func tarArchiveFromPathAs(path, root string) (io.Reader, error) {
 	var buf bytes.Buffer
 	tw := tar.NewWriter(&buf)
-	dir := filepath.Dir(path)
 
 	ok := filepath.Walk(path, func(file string, fi os.FileInfo, err error) (out error) {
 		defer util.RecoverPanic(func(e error) {
@@ -96,11 +209,18 @@ func tarArchiveFromPath(path string) (io.Reader, error) {
 
 		util.PanicIfErr(err)
 
-		header, err := tar.FileInfoHeader(fi, fi.Name())
+		link := ""
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(file)
+			util.PanicIfErr(err)
+		}
+
+		header, err := tar.FileInfoHeader(fi, link)
 		util.PanicIfErr(err)
 
-		relativeDir := strings.Replace(file, dir, "", -1)
-		header.Name = strings.TrimPrefix(relativeDir, string(filepath.Separator))
+		rel, err := filepath.Rel(path, file)
+		util.PanicIfErr(err)
+		header.Name = filepath.Join(root, rel)
 
 		// convert path to linux path
 		if util.IsWindows() {
@@ -110,17 +230,16 @@ func tarArchiveFromPath(path string) (io.Reader, error) {
 		err = tw.WriteHeader(header)
 		util.PanicIfErr(err)
 
-		f, err := os.Open(file)
-		util.PanicIfErr(err)
-
-		if fi.IsDir() {
+		// dirs and symlinks have no content
+		if !fi.Mode().IsRegular() {
 			return
 		}
 
-		_, err = io.Copy(tw, f)
+		f, err := os.Open(file)
 		util.PanicIfErr(err)
+		defer f.Close()
 
-		err = f.Close()
+		_, err = io.Copy(tw, f)
 		util.PanicIfErr(err)
 
 		return
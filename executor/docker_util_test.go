@@ -1,10 +1,16 @@
 package executor
 
 import (
+	"github.com/docker/docker/api/types"
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
 	"github.com/stretchr/testify/assert"
 	"io/ioutil"
+	"os"
+	"os/exec"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestShouldTarAndUntarDir(t *testing.T) {
@@ -23,4 +29,119 @@ func TestShouldTarAndUntarDir(t *testing.T) {
 
 	err = untarFromReader(reader, dest)
 	assert.NoError(err)
-}
\ No newline at end of file
+}
+
+func TestShouldResolveImageDigest(t *testing.T) {
+	assert := assert.New(t)
+
+	inspect := types.ImageInspect{
+		ID: "sha256:111",
+		RepoDigests: []string{
+			"mirror.io/ubuntu@sha256:222",
+			"localhost:5000/ubuntu@sha256:333",
+		},
+	}
+
+	assert.Equal("localhost:5000/ubuntu@sha256:333", resolveImageDigest(inspect, "localhost:5000/ubuntu:18.04"))
+	assert.Equal("mirror.io/ubuntu@sha256:222", resolveImageDigest(inspect, "mirror.io/ubuntu"))
+
+	// should not use digest of other repository
+	assert.Equal("sha256:111", resolveImageDigest(inspect, "ubuntu"))
+
+	inspect.RepoDigests = nil
+	assert.Equal("sha256:111", resolveImageDigest(inspect, "ubuntu:18.04"))
+}
+
+func TestShouldCleanupWorkspaceByScript(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, err := ioutil.TempDir("", "test_ws_")
+	assert.NoError(err)
+	defer os.RemoveAll(ws)
+
+	for i, flow := range []string{"flow-a", "flow-b", "flow-c", "bin", ".plugins"} {
+		assert.NoError(os.MkdirAll(filepath.Join(ws, flow), os.ModePerm))
+		mtime := time.Now().Add(time.Duration(-10+i) * time.Minute)
+		assert.NoError(os.Chtimes(filepath.Join(ws, flow), mtime, mtime))
+	}
+
+	script := workspaceCleanupScript(ws, "flow-d", &domain.WorkspaceCleanup{
+		Policy:     &domain.WorkspacePolicy{KeepFlows: 2},
+		CleanFlows: []string{"flow-a"},
+	})
+
+	output, err := exec.Command(linuxBash, "-c", script).CombinedOutput()
+	assert.NoError(err)
+	assert.Contains(string(output), "Workspace: flow dir flow-a cleaned")
+	assert.Contains(string(output), "Workspace: flow dir flow-b evicted, keep 2 flows")
+
+	assert.False(util.IsFileExists(filepath.Join(ws, "flow-a")))
+	assert.False(util.IsFileExists(filepath.Join(ws, "flow-b")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-c")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-d")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "bin")))
+	assert.True(util.IsFileExists(filepath.Join(ws, ".plugins")))
+}
+
+func TestShouldPrepareJobDirByScript(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, err := ioutil.TempDir("", "test_ws_")
+	assert.NoError(err)
+	defer os.RemoveAll(ws)
+
+	assert.NoError(os.MkdirAll(filepath.Join(ws, "flow", "job-1"), os.ModePerm))
+	assert.NoError(os.MkdirAll(filepath.Join(ws, "flow", "job-2"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(ws, "flow", "job-1", "a.txt"), []byte("a"), 0644))
+
+	// job-1 succeeded and released by the agent, job-2 is held by the other agent
+	output, err := exec.Command(linuxBash, "-c", releaseJobDirScript(ws, "flow/job-1", "agent-a", "step-1", false)).CombinedOutput()
+	assert.NoError(err, string(output))
+
+	output, err = exec.Command(linuxBash, "-c", releaseJobDirScript(ws, "flow/job-2", "agent-b", "step-1", false)).CombinedOutput()
+	assert.NoError(err, string(output))
+
+	output, err = exec.Command(linuxBash, "-c", jobDirScript(ws, "flow/job-3", "agent-a", "step-1", time.Hour, domain.JobDirModeCopy)).CombinedOutput()
+	assert.NoError(err)
+	assert.NotContains(string(output), "removed")
+	assert.Contains(string(output), "Job dir reused from job job-1 (job-copy)")
+
+	assert.Equal("job-1", readJobDirMarker(filepath.Join(ws, "flow")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow", "job-2")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow", "job-3", "a.txt")))
+
+	// failure step of job-3 should be recorded and the job-3 not marked
+	output, err = exec.Command(linuxBash, "-c", releaseJobDirScript(ws, "flow/job-3", "agent-a", "step 2", true)).CombinedOutput()
+	assert.NoError(err, string(output))
+	assert.Equal("step 2", readJobDirState(filepath.Join(ws, "flow"), "job-3").failedStep)
+
+	for _, job := range []string{"job-4", "job-5", "job-6", "job-7"} {
+		assert.NoError(os.MkdirAll(filepath.Join(ws, "flow", job), os.ModePerm))
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	output, err = exec.Command(linuxBash, "-c", jobDirScript(ws, "flow/job-8", "agent-a", "step-1", time.Hour, "")).CombinedOutput()
+	assert.NoError(err)
+	assert.Contains(string(output), "Job dir of job job-3 removed")
+	assert.Equal("job-1", readJobDirMarker(filepath.Join(ws, "flow")))
+
+	for _, job := range []string{"job-1", "job-2", "job-5", "job-6", "job-7", "job-8"} {
+		assert.True(util.IsFileExists(filepath.Join(ws, "flow", job)), job)
+	}
+}
+
+func TestShouldVerifyImagePlatform(t *testing.T) {
+	assert := assert.New(t)
+
+	raw := []byte(`{"Os": "linux", "Architecture": "arm", "Variant": "v7"}`)
+
+	assert.NoError(verifyImagePlatform(raw, "ubuntu", ""))
+	assert.NoError(verifyImagePlatform(raw, "ubuntu", "linux/arm"))
+	assert.NoError(verifyImagePlatform(raw, "ubuntu", "linux/arm/v7"))
+
+	err := verifyImagePlatform(raw, "ubuntu", "linux/arm/v6")
+	assert.Error(err)
+	assert.Contains(err.Error(), "the image platform is 'linux/arm/v7'")
+
+	assert.Error(verifyImagePlatform(raw, "ubuntu", "linux/amd64"))
+}
@@ -0,0 +1,7 @@
+package executor
+
+import "errors"
+
+var (
+	ErrCgroupNotSupported = errors.New("agent: cgroup v2 is not supported on the host")
+)
@@ -7,6 +7,9 @@ import (
 	"github.com/flowci/flow-agent-x/domain"
 	"github.com/flowci/flow-agent-x/util"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -20,8 +23,15 @@ const (
 	defaultChannelBufferSize  = 1000
 	defaultLogWaitingDuration = 5 * time.Second
 	defaultReaderBufferSize   = 8 * 1024 // 8k
+	defaultCgroupParent       = "flow-ci-agent"
 )
 
+// HashFilesFunc sha256 of files in job dir that matched patterns
+type HashFilesFunc func(patterns []string) (string, error)
+
+// CacheLoader download cache with key that could be resolved by hash of files in job dir, return the cache dir
+type CacheLoader func(hashFiles HashFilesFunc) string
+
 type Executor interface {
 	Init() error
 
@@ -60,17 +70,27 @@ type BaseExecutor struct {
 	pluginDir string
 	jobDir    string // job workspace
 
+	jobDirMode string // flow or job scoped dir, see domain.JobDirMode*
+	plugin     *domain.PluginRef
+
+	cacheLoader    CacheLoader
 	cacheInputDir  string // downloaded cache temp dir
 	cacheOutputDir string // temp dir that need to upload
 
 	os         string // current operation system
-	context    context.Context
+	parent     context.Context
+	killFunc   context.CancelFunc
+	context    context.Context // context of current attempt
 	cancelFunc context.CancelFunc
+	timeout    time.Duration
 
 	volumes []*domain.DockerVolume
 	inCmd   *domain.ShellIn
 	result  *domain.ShellOut
 
+	resources    *domain.ResourceLimit // resource limits merged from cmd and agent defaults
+	cgroupParent string
+
 	vars       domain.Variables // vars from input and in cmd
 	secretVars domain.Variables
 	configVars domain.Variables
@@ -94,11 +114,18 @@ type Options struct {
 	WorkspaceFromDockerVolume bool
 	PluginDir                 string
 	CacheSrcDir               string
+	CacheLoader               CacheLoader // load cache after job dir is ready, the CacheSrcDir is ignored if it's set
 	Cmd                       *domain.ShellIn
 	Vars                      domain.Variables
 	SecretVars                domain.Variables
 	ConfigVars                domain.Variables
 	Volumes                   []*domain.DockerVolume
+	DefaultResources          *domain.ResourceLimit
+	CgroupParent              string
+	DockerHost                *domain.DockerHost
+	WorkspaceCleanup          *domain.WorkspaceCleanup // cleanup of docker workspace volume
+	JobDirMode                string
+	Plugin                    *domain.PluginRef // loaded plugin of cmd
 }
 
 func NewExecutor(options Options) Executor {
@@ -106,12 +133,19 @@ func NewExecutor(options Options) Executor {
 		options.Vars = domain.NewVariables()
 	}
 
+	if util.IsEmptyString(options.CgroupParent) {
+		options.CgroupParent = defaultCgroupParent
+	}
+
 	cmd := options.Cmd
 	base := BaseExecutor{
 		k8sConfig:     options.K8s,
 		agentId:       options.AgentId,
 		workspace:     options.Workspace,
 		pluginDir:     options.PluginDir,
+		jobDirMode:    options.JobDirMode,
+		plugin:        options.Plugin,
+		cacheLoader:   options.CacheLoader,
 		cacheInputDir: options.CacheSrcDir,
 		volumes:       options.Volumes,
 		stdout:        make(chan string, defaultChannelBufferSize),
@@ -120,18 +154,32 @@ func NewExecutor(options Options) Executor {
 		secretVars:    options.SecretVars,
 		configVars:    options.ConfigVars,
 		result:        domain.NewShellOutput(cmd),
+		resources:     cmd.Resources.WithDefault(options.DefaultResources),
+		cgroupParent:  options.CgroupParent,
 		ttyIn:         make(chan string, defaultChannelBufferSize),
 		ttyOut:        make(chan string, defaultChannelBufferSize),
 	}
 
-	ctx, cancel := context.WithTimeout(options.Parent, time.Duration(cmd.Timeout)*time.Second)
-	base.context = ctx
-	base.cancelFunc = cancel
+	if options.Plugin != nil {
+		base.result.PluginCommit = options.Plugin.Commit
+	}
+
+	base.timeout = time.Duration(cmd.Timeout) * time.Second
+	base.parent, base.killFunc = context.WithCancel(options.Parent)
+	base.context, base.cancelFunc = context.WithTimeout(base.parent, base.timeout)
+
+	if cmd.HasDockerOption() && base.isK8sEnabled() && options.K8s.PodExecutor {
+		return &k8sExecutor{
+			BaseExecutor: &base,
+		}
+	}
 
 	if cmd.HasDockerOption() {
 		return &dockerExecutor{
 			BaseExecutor:       base,
 			wsFromDockerVolume: options.WorkspaceFromDockerVolume,
+			dockerHost:         options.DockerHost,
+			wsCleanup:          options.WorkspaceCleanup,
 		}
 	}
 
@@ -175,7 +223,7 @@ func (b *BaseExecutor) GetResult() *domain.ShellOut {
 }
 
 func (b *BaseExecutor) Kill() {
-	b.cancelFunc()
+	b.killFunc()
 }
 
 func (b *BaseExecutor) Close() {
@@ -188,6 +236,7 @@ func (b *BaseExecutor) Close() {
 	close(b.ttyOut)
 
 	b.cancelFunc()
+	b.killFunc()
 }
 
 //====================================================================
@@ -198,6 +247,60 @@ func (b *BaseExecutor) isK8sEnabled() bool {
 	return b.k8sConfig != nil && b.k8sConfig.Enabled
 }
 
+// initPluginDirVar export dir of the loaded plugin under plugin root
+func (b *BaseExecutor) initPluginDirVar(root, separator string) {
+	if b.plugin == nil {
+		return
+	}
+	b.vars[domain.VarPluginDir] = root + separator + b.plugin.DirName()
+}
+
+// runWithRetry run the attempt until it's finished or the result not matched the retry policy
+func (b *BaseExecutor) runWithRetry(attempt func() error) (out error) {
+	policy := b.inCmd.GetRetryPolicy()
+
+	for i := 0; i <= b.inCmd.Retry; i++ {
+		if i > 0 {
+			delay := policy.DelayOf(i)
+			b.writeSingleLog(fmt.Sprintf(">>>>>>> retry %d/%d in %s >>>>>>>", i, b.inCmd.Retry, delay))
+
+			if !b.waitForRetry(delay) {
+				b.toKilledStatus()
+				return nil
+			}
+
+			b.resetContext()
+			b.result.ResetForRetry()
+		}
+
+		startAt := time.Now()
+		out = attempt()
+		b.result.AddAttempt(i+1, startAt)
+
+		if !policy.ShouldRetry(b.result, out) {
+			return
+		}
+	}
+
+	return
+}
+
+// waitForRetry return false if cmd been killed while waiting
+func (b *BaseExecutor) waitForRetry(delay time.Duration) bool {
+	select {
+	case <-b.parent.Done():
+		return false
+	case <-time.After(delay):
+		return b.parent.Err() == nil
+	}
+}
+
+// resetContext create a new timeout context for the next attempt
+func (b *BaseExecutor) resetContext() {
+	b.cancelFunc()
+	b.context, b.cancelFunc = context.WithTimeout(b.parent, b.timeout)
+}
+
 func (b *BaseExecutor) writeCmd(stdin io.Writer, before, after func() []string, doScript func(string) string) {
 	write := func(script string) {
 		_, _ = io.WriteString(stdin, appendNewLine(script, b.os))
@@ -371,3 +474,93 @@ func (b *BaseExecutor) toFinishStatus(exitCode int) {
 	_ = b.toErrorStatus(fmt.Errorf("exit status %d", exitCode))
 	return
 }
+
+// load cache by loader once, the hashFiles is evaluated against the job dir
+func (b *BaseExecutor) loadCache(hashFiles HashFilesFunc) {
+	if b.cacheLoader == nil {
+		return
+	}
+
+	b.cacheInputDir = b.cacheLoader(hashFiles)
+	b.cacheLoader = nil
+}
+
+// hashFilesInJobDir sha256 of files that matched patterns in local job dir
+func (b *BaseExecutor) hashFilesInJobDir(patterns []string) (string, error) {
+	return util.HashFiles(b.jobDir, patterns)
+}
+
+// move cache to local job dir if cache defined in cacheSrcDir
+func (b *BaseExecutor) moveCacheToJobDir() {
+	if !util.HasString(b.cacheInputDir) {
+		return
+	}
+
+	files, err := ioutil.ReadDir(b.cacheInputDir)
+	util.PanicIfErr(err)
+
+	for _, f := range files {
+		oldPath := filepath.Join(b.cacheInputDir, f.Name())
+		newPath := filepath.Join(b.jobDir, f.Name())
+
+		if util.IsFileExists(newPath) {
+			_ = os.Remove(newPath)
+		}
+
+		// move cache from src dir to job dir
+		err = os.Rename(oldPath, newPath)
+
+		if err == nil {
+			b.writeSingleLog(fmt.Sprintf("cache %s has been applied", f.Name()))
+		} else {
+			b.writeSingleLog(fmt.Sprintf("cache %s not applied: %s", f.Name(), err.Error()))
+		}
+
+		// remove cache from cache dir anyway
+		_ = os.RemoveAll(oldPath)
+	}
+}
+
+// copy cache paths from local job dir to cache output dir
+func (b *BaseExecutor) copyCacheFromJobDir() {
+	if !b.inCmd.HasCache() {
+		return
+	}
+
+	defer util.RecoverPanic(func(e error) {
+		util.LogWarn(e.Error())
+	})
+
+	dir, err := ioutil.TempDir("", "_cache_output_")
+	if err != nil {
+		util.LogWarn(err.Error())
+		return
+	}
+
+	b.cacheOutputDir = dir
+	cache := b.inCmd.Cache
+
+	for _, path := range cache.Paths {
+		path = filepath.Clean(path)
+		fullPath := filepath.Join(b.jobDir, path)
+
+		info, exist := util.IsFileExistsAndReturnFileInfo(fullPath)
+		if !exist {
+			continue
+		}
+
+		newPath := filepath.Join(dir, path)
+
+		if info.IsDir() {
+			err := util.CopyDir(fullPath, newPath)
+			util.PanicIfErr(err)
+
+			util.LogDebug("dir %s write back to cache dir", newPath)
+			continue
+		}
+
+		err := util.CopyFile(fullPath, newPath)
+		util.PanicIfErr(err)
+		util.LogDebug("file %s write back to cache dir", newPath)
+	}
+}
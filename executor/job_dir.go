@@ -0,0 +1,284 @@
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+)
+
+const (
+	// jobDirMarker file under flow dir, which contains the job id of last successful job dir
+	jobDirMarker = ".last-success"
+
+	// jobDirStatePrefix state file of job under flow dir, ex: .job-{job id}, it's content is
+	// '{holder} {lease expire at in unix seconds} {id of failed step}', the job dir is held while the lease is valid
+	jobDirStatePrefix = ".job-"
+
+	// jobDirIdleTimeout lease of job dir after step finished, to wait for the next step of the job
+	jobDirIdleTimeout = time.Hour
+
+	// jobDirRetention number of finished job dirs to keep in flow dir, except the last successful one
+	jobDirRetention = 3
+)
+
+type jobDirState struct {
+	holder     string
+	expireAt   int64
+	failedStep string // empty if no failure step in the job
+}
+
+// jobDirName get job dir relative to workspace with '/' as separator
+func (b *BaseExecutor) jobDirName() string {
+	flowId := util.ParseString(b.inCmd.FlowId)
+	if !domain.IsJobScopedDir(b.jobDirMode) {
+		return flowId
+	}
+	return flowId + "/" + util.ParseString(b.inCmd.JobId)
+}
+
+// prepareJobDir create job dir under workspace on the host and hold it until the job finished,
+// the finished jobs of the flow will be resolved, and the stale job dirs out of retention will be removed,
+// the last successful job dir will be copied or linked if the job dir is empty on reuse mode
+func (b *BaseExecutor) prepareJobDir(ws string) string {
+	jobDir := filepath.Join(ws, filepath.FromSlash(b.jobDirName()))
+	if !domain.IsJobScopedDir(b.jobDirMode) {
+		util.PanicIfErr(os.MkdirAll(jobDir, os.ModePerm))
+		return jobDir
+	}
+
+	flowDir := filepath.Dir(jobDir)
+	jobId := filepath.Base(jobDir)
+
+	util.PanicIfErr(os.MkdirAll(flowDir, os.ModePerm))
+
+	b.finishJobDirs(flowDir, jobId)
+	b.holdJobDir(flowDir, jobId, b.timeout*time.Duration(b.inCmd.Retry+1)+b.inCmd.GetGracePeriod()+jobDirIdleTimeout, false)
+
+	source := readJobDirMarker(flowDir)
+	for _, name := range staleJobDirs(flowDir, jobId, source) {
+		util.PanicIfErr(os.RemoveAll(filepath.Join(flowDir, name)))
+		b.writeSingleLog(fmt.Sprintf("Job dir of job %s removed", name))
+	}
+
+	util.PanicIfErr(os.MkdirAll(jobDir, os.ModePerm))
+
+	if !b.isReuseJobDir() || util.IsEmptyString(source) || source == jobId || !isEmptyDir(jobDir) {
+		return jobDir
+	}
+
+	sourceDir := filepath.Join(flowDir, source)
+	if !util.IsFileExists(sourceDir) {
+		return jobDir
+	}
+
+	err := copyDirTree(sourceDir, jobDir, b.jobDirMode == domain.JobDirModeLink)
+	util.PanicIfErr(err)
+
+	b.writeSingleLog(fmt.Sprintf("Job dir reused from job %s (%s)", source, b.jobDirMode))
+	return jobDir
+}
+
+// releaseJobDir renew the lease of job dir for the next step of the job, and record the failure of step,
+// the job dir will be marked as last successful one after the job finished without failure step
+func (b *BaseExecutor) releaseJobDir(ws string) {
+	if !domain.IsJobScopedDir(b.jobDirMode) {
+		return
+	}
+
+	jobDir := filepath.Join(ws, filepath.FromSlash(b.jobDirName()))
+	b.holdJobDir(filepath.Dir(jobDir), filepath.Base(jobDir), jobDirIdleTimeout, b.isStepFailed())
+}
+
+// holdJobDir write state of job with the lease, the failure step can only be cleared by the same step on retry
+func (b *BaseExecutor) holdJobDir(flowDir, jobId string, lease time.Duration, failed bool) {
+	state := readJobDirState(flowDir, jobId)
+	if state == nil {
+		state = &jobDirState{}
+	}
+
+	stepId := b.inCmd.ID
+	if failed {
+		state.failedStep = stepId
+	} else if state.failedStep == stepId {
+		state.failedStep = ""
+	}
+
+	state.holder = b.jobDirHolder()
+	state.expireAt = time.Now().Add(lease).Unix()
+	util.LogIfError(writeJobDirState(flowDir, jobId, state))
+}
+
+// finishJobDirs resolve jobs that finished, which is the job released by this agent since agent runs one job at a time,
+// or the lease is expired. The last one without failure step is marked as last successful job dir
+func (b *BaseExecutor) finishJobDirs(flowDir, current string) {
+	files, err := ioutil.ReadDir(flowDir)
+	util.PanicIfErr(err)
+
+	var last *jobDirState
+	now := time.Now().Unix()
+
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), jobDirStatePrefix) {
+			continue
+		}
+
+		jobId := strings.TrimPrefix(f.Name(), jobDirStatePrefix)
+		state := readJobDirState(flowDir, jobId)
+		if jobId == current || state == nil {
+			continue
+		}
+
+		if state.holder != b.jobDirHolder() && state.expireAt > now {
+			continue
+		}
+
+		if util.IsEmptyString(state.failedStep) && (last == nil || state.expireAt > last.expireAt) {
+			last = state
+			util.LogIfError(ioutil.WriteFile(filepath.Join(flowDir, jobDirMarker), []byte(jobId), 0644))
+		}
+
+		_ = os.Remove(filepath.Join(flowDir, f.Name()))
+	}
+}
+
+func (b *BaseExecutor) jobDirHolder() string {
+	if util.IsEmptyString(b.agentId) {
+		return "-"
+	}
+	return b.agentId
+}
+
+// isStepFailed the step failure makes the job failed, unless it's allowed
+func (b *BaseExecutor) isStepFailed() bool {
+	if b.inCmd.AllowFailure {
+		return false
+	}
+	return b.result.Status != domain.CmdStatusSuccess && b.result.Status != domain.CmdStatusSkipped
+}
+
+func (b *BaseExecutor) isReuseJobDir() bool {
+	return b.jobDirMode == domain.JobDirModeCopy || b.jobDirMode == domain.JobDirModeLink
+}
+
+func readJobDirMarker(flowDir string) string {
+	raw, err := ioutil.ReadFile(filepath.Join(flowDir, jobDirMarker))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(raw))
+}
+
+func readJobDirState(flowDir, jobId string) *jobDirState {
+	raw, err := ioutil.ReadFile(filepath.Join(flowDir, jobDirStatePrefix+jobId))
+	if err != nil {
+		return nil
+	}
+
+	fields := strings.SplitN(strings.TrimSpace(string(raw)), " ", 3)
+	if len(fields) < 2 {
+		return nil
+	}
+
+	expireAt, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return nil
+	}
+
+	state := &jobDirState{holder: fields[0], expireAt: expireAt}
+	if len(fields) == 3 {
+		state.failedStep = fields[2]
+	}
+	return state
+}
+
+func writeJobDirState(flowDir, jobId string, state *jobDirState) error {
+	content := fmt.Sprintf("%s %d %s", state.holder, state.expireAt, state.failedStep)
+	return ioutil.WriteFile(filepath.Join(flowDir, jobDirStatePrefix+jobId), []byte(content), 0644)
+}
+
+// staleJobDirs the job dirs that are not held by running jobs, and out of retention, ordered by modified time
+func staleJobDirs(flowDir, current, source string) []string {
+	files, err := ioutil.ReadDir(flowDir)
+	util.PanicIfErr(err)
+
+	var finished []os.FileInfo
+	for _, f := range files {
+		name := f.Name()
+		if !f.IsDir() || name == current || name == source {
+			continue
+		}
+
+		if util.IsFileExists(filepath.Join(flowDir, jobDirStatePrefix+name)) {
+			continue
+		}
+
+		finished = append(finished, f)
+	}
+
+	if len(finished) <= jobDirRetention {
+		return nil
+	}
+
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].ModTime().After(finished[j].ModTime())
+	})
+
+	var stale []string
+	for _, f := range finished[jobDirRetention:] {
+		stale = append(stale, f.Name())
+	}
+	return stale
+}
+
+func isEmptyDir(dir string) bool {
+	files, err := ioutil.ReadDir(dir)
+	return err == nil && len(files) == 0
+}
+
+// copyDirTree copy files from src to existing dst dir, the file will be hardlinked if link is true,
+// and fallback to copy if hardlink not supported, ex: across devices
+func copyDirTree(src, dst string, link bool) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(target, info.Mode())
+		case info.Mode()&os.ModeSymlink != 0:
+			dest, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(dest, target)
+		case !info.Mode().IsRegular():
+			return nil
+		}
+
+		if link && os.Link(path, target) == nil {
+			return nil
+		}
+
+		if err = util.CopyFile(path, target); err != nil {
+			return err
+		}
+
+		return os.Chmod(target, info.Mode())
+	})
+}
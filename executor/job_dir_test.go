@@ -0,0 +1,124 @@
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldPrepareJobDirAndReuseLastSuccessful(t *testing.T) {
+	assert := assert.New(t)
+
+	ws, err := ioutil.TempDir("", "test_ws_")
+	assert.NoError(err)
+	defer os.RemoveAll(ws)
+
+	flowDir := filepath.Join(ws, "flow")
+
+	// run steps of job on the agent, the step is failed if status is not success
+	runJob := func(agentId, jobId string, statuses ...domain.CmdStatus) string {
+		var jobDir string
+		for i, status := range statuses {
+			b := &BaseExecutor{
+				agentId:    agentId,
+				inCmd:      &domain.ShellIn{ID: fmt.Sprintf("%s-step-%d", jobId, i), FlowId: "flow", JobId: jobId},
+				result:     &domain.ShellOut{},
+				jobDirMode: domain.JobDirModeLink,
+				stdout:     make(chan string, defaultChannelBufferSize),
+			}
+
+			jobDir = b.prepareJobDir(ws)
+			b.result.Status = status
+			b.releaseJobDir(ws)
+		}
+		return jobDir
+	}
+
+	// first job with two successful steps, marked as last successful when the agent moved to the next job
+	jobDir := runJob("agent-a", "job-1", domain.CmdStatusSuccess, domain.CmdStatusSuccess)
+	assert.Equal(filepath.Join(ws, "flow", "job-1"), jobDir)
+	assert.NoError(os.MkdirAll(filepath.Join(jobDir, "src"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(jobDir, "src", "main.go"), []byte("package main"), 0644))
+	assert.Equal("", readJobDirMarker(flowDir))
+
+	// second job failed on the last step, reuse from job-1 and not marked
+	jobDir = runJob("agent-a", "job-2", domain.CmdStatusSuccess, domain.CmdStatusException)
+	assert.Equal("job-1", readJobDirMarker(flowDir))
+
+	content, err := ioutil.ReadFile(filepath.Join(jobDir, "src", "main.go"))
+	assert.NoError(err)
+	assert.Equal("package main", string(content))
+
+	// third job recovered the failure step by retry
+	third := &BaseExecutor{
+		agentId:    "agent-a",
+		inCmd:      &domain.ShellIn{ID: "job-3-step-0", FlowId: "flow", JobId: "job-3"},
+		result:     &domain.ShellOut{Status: domain.CmdStatusTimeout},
+		jobDirMode: domain.JobDirModeLink,
+		stdout:     make(chan string, defaultChannelBufferSize),
+	}
+	third.prepareJobDir(ws)
+	assert.Equal("job-1", readJobDirMarker(flowDir))
+
+	third.releaseJobDir(ws)
+	assert.Equal("job-3-step-0", readJobDirState(flowDir, "job-3").failedStep)
+
+	third.result.Status = domain.CmdStatusSuccess
+	third.releaseJobDir(ws)
+	assert.Equal("", readJobDirState(flowDir, "job-3").failedStep)
+
+	// job-4 is running on the other agent, should not be removed or marked
+	runJob("agent-b", "job-4", domain.CmdStatusSuccess)
+
+	for _, jobId := range []string{"job-5", "job-6", "job-7"} {
+		runJob("agent-a", jobId, domain.CmdStatusException)
+	}
+
+	assert.Equal("job-3", readJobDirMarker(flowDir))
+	assert.True(util.IsFileExists(filepath.Join(flowDir, jobDirStatePrefix+"job-4")))
+
+	// job-1 and job-2 out of retention, job-3 kept as source
+	runJob("agent-a", "job-8", domain.CmdStatusSuccess)
+
+	assert.False(util.IsFileExists(filepath.Join(flowDir, "job-1")))
+	assert.False(util.IsFileExists(filepath.Join(flowDir, "job-2")))
+	for _, jobId := range []string{"job-3", "job-4", "job-5", "job-6", "job-7", "job-8"} {
+		assert.True(util.IsFileExists(filepath.Join(flowDir, jobId)), jobId)
+	}
+}
+
+func TestShouldFinishJobDirWhenLeaseExpired(t *testing.T) {
+	assert := assert.New(t)
+
+	flowDir, err := ioutil.TempDir("", "test_flow_")
+	assert.NoError(err)
+	defer os.RemoveAll(flowDir)
+
+	expired := &jobDirState{holder: "agent-b", expireAt: time.Now().Add(-time.Minute).Unix()}
+	assert.NoError(writeJobDirState(flowDir, "job-1", expired))
+
+	running := &jobDirState{holder: "agent-b", expireAt: time.Now().Add(time.Minute).Unix(), failedStep: "step 1"}
+	assert.NoError(writeJobDirState(flowDir, "job-2", running))
+	assert.Equal(running, readJobDirState(flowDir, "job-2"))
+
+	b := &BaseExecutor{agentId: "agent-a", inCmd: &domain.ShellIn{}}
+	b.finishJobDirs(flowDir, "job-3")
+
+	assert.Equal("job-1", readJobDirMarker(flowDir))
+	assert.Nil(readJobDirState(flowDir, "job-1"))
+	assert.NotNil(readJobDirState(flowDir, "job-2"))
+}
+
+func TestShouldUseFlowDirByDefault(t *testing.T) {
+	assert := assert.New(t)
+
+	b := &BaseExecutor{inCmd: &domain.ShellIn{FlowId: "flow", JobId: "job-1"}}
+	assert.Equal("flow", b.jobDirName())
+}
@@ -0,0 +1,506 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	k8sStepContainer     = "step"
+	k8sWorkspaceVolume   = "workspace"
+	k8sPodNamePrefix     = "flowci-step-"
+	k8sPodPollInterval   = time.Second
+	k8sMaxResourceName   = 63
+	k8sLabelApp          = "app"
+	k8sLabelAppValue     = "flowci-step"
+	k8sLabelFlowId       = "flow.ci/flow-id"
+	k8sLabelJobId        = "flow.ci/job-id"
+	k8sLocalhost         = "127.0.0.1"
+	k8sEnvFilePattern    = ".env-%s"
+	k8sContainerIdFormat = "%s/%s" // pod name/container name
+)
+
+var (
+	k8sInvalidNameChars = regexp.MustCompile("[^a-z0-9-]+")
+
+	// waiting reasons that the container never be started
+	k8sFailedWaitingReasons = map[string]bool{
+		"ErrImagePull":               true,
+		"ImagePullBackOff":           true,
+		"InvalidImageName":           true,
+		"CreateContainerConfigError": true,
+		"CreateContainerError":       true,
+	}
+
+	k8sPullPolicies = map[string]corev1.PullPolicy{
+		domain.DockerPullAlways:       corev1.PullAlways,
+		domain.DockerPullIfNotPresent: corev1.PullIfNotPresent,
+		domain.DockerPullNever:        corev1.PullNever,
+	}
+)
+
+type (
+	// k8sExecutor run step as k8s pod, the runtime is the step container and the other dockers are sidecars,
+	// the agent workspace pvc is mounted to the pod at the same path, so that job dir, plugins and cache are shared
+	k8sExecutor struct {
+		*BaseExecutor
+		client    kubernetes.Interface
+		namespace string
+		podName   string
+		envFile   string
+		binDir    string
+	}
+)
+
+func (k *k8sExecutor) Init() (out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = e
+	})
+
+	k.os = util.OSLinux
+	k.result.StartAt = time.Now()
+
+	if util.IsEmptyString(k.k8sConfig.WorkspaceClaim) {
+		panic(fmt.Errorf("k8s: workspace claim is required for pod executor"))
+	}
+
+	// containers in a pod start together, the readiness of service cannot hold the step container
+	for _, option := range k.inCmd.Dockers {
+		if option.HealthCheck != nil {
+			panic(fmt.Errorf("k8s: health check of docker '%s' is not supported by pod executor", option.Image))
+		}
+	}
+
+	if k.client == nil {
+		k.client, k.namespace, out = newK8sClient(k.k8sConfig.Namespace)
+		util.PanicIfErr(out)
+	}
+
+	// setup bin under workspace
+	k.binDir = filepath.Join(k.workspace, "bin")
+	err := os.MkdirAll(k.binDir, os.ModePerm)
+	util.PanicIfErr(err)
+
+	for _, f := range binFiles {
+		path := filepath.Join(k.binDir, f.name)
+		if !util.IsFileExists(path) {
+			_ = ioutil.WriteFile(path, f.content, f.permission)
+		}
+	}
+
+	// setup job dir under workspace
+	k.jobDir = k.prepareJobDir(k.workspace)
+	k.vars[domain.VarAgentWorkspace] = k.workspace
+	k.vars[domain.VarAgentJobDir] = k.jobDir
+	k.vars[domain.VarAgentPluginDir] = k.pluginDir
+	k.initPluginDirVar(k.pluginDir, util.UnixPathSeparator)
+	k.checkout(k.jobDir)
+
+	k.vars.Resolve()
+	k.loadCache(k.hashFilesInJobDir)
+	k.moveCacheToJobDir()
+	return nil
+}
+
+func (k *k8sExecutor) Start() (out error) {
+	out = k.runWithRetry(k.doStart)
+	k.copyCacheFromJobDir()
+	k.releaseJobDir(k.workspace)
+	return
+}
+
+func (k *k8sExecutor) StartTty(ttyId string, onStarted func(ttyId string)) error {
+	return fmt.Errorf("k8s: interactive tty is not supported by pod executor")
+}
+
+func (k *k8sExecutor) StopTty() {
+	// tty not supported
+}
+
+//====================================================================
+//	private
+//====================================================================
+
+func (k *k8sExecutor) doStart() (out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = k.handleErrors(e)
+	})
+
+	k.podName = k8sResourceName(fmt.Sprintf("%s%s-%d", k8sPodNamePrefix, k.inCmd.ID, len(k.result.Attempts)+1))
+	k.envFile = filepath.Join(k.workspace, fmt.Sprintf(k8sEnvFilePattern, k.podName))
+	defer k.cleanupPod()
+
+	k.createSecret()
+
+	pod := k.buildPod()
+	_, err := k.client.CoreV1().Pods(k.namespace).Create(k.context, pod, metav1.CreateOptions{})
+	util.PanicIfErr(err)
+	k.writeSingleLog(fmt.Sprintf("Pod %s created", k.podName))
+
+	k.waitForStarted()
+	k.toStartStatus(0)
+
+	// stream log of step container
+	k.stdOutWg.Add(1)
+	logs, err := k.client.CoreV1().Pods(k.namespace).GetLogs(k.podName, &corev1.PodLogOptions{
+		Container: k8sStepContainer,
+		Follow:    true,
+	}).Stream(k.context)
+	if err != nil {
+		k.stdOutWg.Done()
+		panic(err)
+	}
+
+	streamDone := make(chan struct{})
+	go func() {
+		defer close(streamDone)
+		defer logs.Close()
+		k.writeLog(logs, false, true)
+	}()
+
+	exitCode := k.waitForExit(streamDone)
+	k.exportEnv()
+
+	if k.result.IsFinishStatus() {
+		return nil
+	}
+
+	k.toFinishStatus(exitCode)
+	return
+}
+
+func (k *k8sExecutor) buildPod() *corev1.Pod {
+	grace := int64(k.inCmd.GetGracePeriod().Seconds())
+
+	pod := &corev1.Pod{
+		ObjectMeta: k.objectMeta(),
+		Spec: corev1.PodSpec{
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			NodeName:                      k.k8sConfig.NodeName,
+			TerminationGracePeriodSeconds: &grace,
+			Volumes: []corev1.Volume{
+				{
+					Name: k8sWorkspaceVolume,
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: k.k8sConfig.WorkspaceClaim},
+					},
+				},
+			},
+		},
+	}
+
+	runtimeOption := k.runtimeOption()
+	for i, option := range k.inCmd.Dockers {
+		if option.HasAuth() {
+			util.LogWarn("k8s: auth '%s' of image %s is ignored, please use imagePullSecrets of service account", option.Auth, option.Image)
+		}
+
+		if option == runtimeOption {
+			pod.Spec.Containers = append([]corev1.Container{k.toStepContainer(option)}, pod.Spec.Containers...)
+			continue
+		}
+
+		config := option.ToConfig()
+		c := toK8sContainer(config)
+		c.Name = k8sServiceContainerName(i, option)
+
+		c.Command = config.Config.Entrypoint
+		c.Args = config.Config.Cmd
+		pod.Spec.Containers = append(pod.Spec.Containers, c)
+	}
+
+	return pod
+}
+
+// objectMeta the meta of pod and secret of the step, which are named by pod name
+func (k *k8sExecutor) objectMeta() metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:      k.podName,
+		Namespace: k.namespace,
+		Labels: map[string]string{
+			k8sLabelApp:    k8sLabelAppValue,
+			k8sLabelFlowId: k8sResourceName(k.inCmd.FlowId),
+			k8sLabelJobId:  k8sResourceName(k.inCmd.JobId),
+		},
+	}
+}
+
+// runtimeOption get the runtime docker option, the only option is runtime if it's not specified
+func (k *k8sExecutor) runtimeOption() *domain.DockerOption {
+	for _, option := range k.inCmd.Dockers {
+		if option.IsRuntime {
+			return option
+		}
+	}
+
+	if len(k.inCmd.Dockers) > 1 {
+		panic(fmt.Errorf("no runtime docker option available"))
+	}
+
+	return k.inCmd.Dockers[0]
+}
+
+// createSecret create secret of the step with secret vars, which is deleted with the pod
+func (k *k8sExecutor) createSecret() {
+	if len(k.secretVars) == 0 {
+		return
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: k.objectMeta(),
+		Type:       corev1.SecretTypeOpaque,
+		StringData: k.secretVars,
+	}
+
+	_, err := k.client.CoreV1().Secrets(k.namespace).Create(k.context, secret, metav1.CreateOptions{})
+	util.PanicIfErr(err)
+}
+
+// toStepContainer the secret vars are referred from secret of the step, not in the pod spec
+func (k *k8sExecutor) toStepContainer(option *domain.DockerOption) corev1.Container {
+	config := option.ToRuntimeConfig(domain.ConnectVars(k.vars, k.configVars), k.jobDir, nil)
+
+	c := toK8sContainer(config)
+	c.Name = k8sStepContainer
+
+	if len(k.secretVars) > 0 {
+		c.EnvFrom = []corev1.EnvFromSource{
+			{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: k.podName}}},
+		}
+	}
+	c.VolumeMounts = []corev1.VolumeMount{{Name: k8sWorkspaceVolume, MountPath: k.workspace}}
+
+	shell := config.Config.Entrypoint
+	if len(shell) == 0 {
+		shell = []string{linuxBash}
+	}
+
+	c.Command = append(append([]string{}, shell...), "-c", k.buildScript())
+	return c
+}
+
+// buildScript write all scripts into one, which will be run by 'bash -c'
+func (k *k8sExecutor) buildScript() string {
+	setupContainerIpAndBin := func() []string {
+		var scripts []string
+
+		for i, option := range k.inCmd.Dockers {
+			name := k8sStepContainer
+			if option != k.runtimeOption() {
+				name = k8sServiceContainerName(i, option)
+			}
+
+			// containers in the same pod share the network namespace
+			scripts = append(scripts, fmt.Sprintf(domain.VarExportContainerIdPattern, i, fmt.Sprintf(k8sContainerIdFormat, k.podName, name)))
+			scripts = append(scripts, fmt.Sprintf(domain.VarExportContainerIpPattern, i, k8sLocalhost))
+		}
+
+		scripts = append(scripts, fmt.Sprintf("export PATH=%s:$PATH", k.binDir))
+		return scripts
+	}
+
+	// the env file is on the workspace claim, secrets should not be written
+	writeEnvAfter := func() []string {
+		var scripts []string
+		if len(k.secretVars) > 0 {
+			names := make([]string, 0, len(k.secretVars))
+			for name := range k.secretVars {
+				names = append(names, name)
+			}
+
+			sort.Strings(names)
+			scripts = append(scripts, "unset "+strings.Join(names, " "))
+		}
+
+		return append(scripts, "env > "+k.envFile)
+	}
+
+	doScript := func(script string) string {
+		return script
+	}
+
+	var buf bytes.Buffer
+	k.writeCmd(&buf, setupContainerIpAndBin, writeEnvAfter, doScript)
+	return buf.String()
+}
+
+// waitForStarted wait until the step container is running or terminated
+func (k *k8sExecutor) waitForStarted() {
+	for {
+		pod, err := k.client.CoreV1().Pods(k.namespace).Get(k.context, k.podName, metav1.GetOptions{})
+		util.PanicIfErr(err)
+
+		if pod.Status.Phase == corev1.PodFailed {
+			panic(fmt.Errorf("k8s: pod %s failed, %s %s", k.podName, pod.Status.Reason, pod.Status.Message))
+		}
+
+		if status := k8sContainerStatus(pod, k8sStepContainer); status != nil {
+			if status.State.Running != nil || status.State.Terminated != nil {
+				return
+			}
+
+			if waiting := status.State.Waiting; waiting != nil && k8sFailedWaitingReasons[waiting.Reason] {
+				panic(fmt.Errorf("k8s: step container of pod %s cannot be started, %s %s", k.podName, waiting.Reason, waiting.Message))
+			}
+		}
+
+		k.sleep(k8sPodPollInterval)
+	}
+}
+
+// waitForExit wait for the log stream ended and get exit code of step container
+func (k *k8sExecutor) waitForExit(streamDone <-chan struct{}) int {
+	select {
+	case <-streamDone:
+	case <-k.context.Done():
+		panic(k.context.Err())
+	}
+
+	for {
+		pod, err := k.client.CoreV1().Pods(k.namespace).Get(k.context, k.podName, metav1.GetOptions{})
+		util.PanicIfErr(err)
+
+		if status := k8sContainerStatus(pod, k8sStepContainer); status != nil && status.State.Terminated != nil {
+			return int(status.State.Terminated.ExitCode)
+		}
+
+		k.sleep(k8sPodPollInterval)
+	}
+}
+
+// sleep panic with context error if it's done
+func (k *k8sExecutor) sleep(d time.Duration) {
+	select {
+	case <-k.context.Done():
+		panic(k.context.Err())
+	case <-time.After(d):
+	}
+}
+
+func (k *k8sExecutor) exportEnv() {
+	file, err := os.Open(k.envFile)
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		_ = file.Close()
+		_ = os.Remove(k.envFile)
+	}()
+
+	k.result.Output = readEnvFromReader(k.os, file, k.inCmd.EnvFilters)
+}
+
+func (k *k8sExecutor) deletePod(grace time.Duration) {
+	// apply new context since k.context might be cancelled
+	seconds := int64(grace.Seconds())
+	err := k.client.CoreV1().Pods(k.namespace).Delete(context.Background(), k.podName, metav1.DeleteOptions{GracePeriodSeconds: &seconds})
+	if !util.LogIfError(err) {
+		util.LogInfo("Pod %s for cmd %s has been deleted", k.podName, k.inCmd.ID)
+	}
+}
+
+func (k *k8sExecutor) cleanupPod() {
+	if util.IsEmptyString(k.podName) || k.client == nil {
+		return
+	}
+	k.deletePod(0)
+
+	if len(k.secretVars) > 0 {
+		err := k.client.CoreV1().Secrets(k.namespace).Delete(context.Background(), k.podName, metav1.DeleteOptions{})
+		if !util.LogIfError(err) {
+			util.LogDebug("Secret %s for cmd %s has been deleted", k.podName, k.inCmd.ID)
+		}
+	}
+}
+
+func (k *k8sExecutor) handleErrors(err error) error {
+	util.LogWarn("handleError on k8s: %s", err.Error())
+
+	if err == context.DeadlineExceeded {
+		util.LogDebug("Timeout..")
+		k.deletePod(k.inCmd.GetGracePeriod())
+		k.toTimeOutStatus()
+		k.context = context.Background()
+		return nil
+	}
+
+	if err == context.Canceled {
+		util.LogDebug("Cancel..")
+		k.deletePod(k.inCmd.GetGracePeriod())
+		k.toKilledStatus()
+		k.context = context.Background()
+		return nil
+	}
+
+	_ = k.toErrorStatus(err)
+	return err
+}
+
+func toK8sContainer(config *domain.DockerConfig) corev1.Container {
+	c := corev1.Container{
+		Image:           config.Config.Image,
+		ImagePullPolicy: k8sPullPolicies[config.PullPolicy],
+		WorkingDir:      config.Config.WorkingDir,
+		Resources:       corev1.ResourceRequirements{Limits: corev1.ResourceList{}},
+	}
+
+	for _, env := range config.Config.Env {
+		if index := strings.Index(env, "="); index > 0 {
+			c.Env = append(c.Env, corev1.EnvVar{Name: env[:index], Value: env[index+1:]})
+		}
+	}
+
+	if memory := config.Host.Resources.Memory; memory > 0 {
+		c.Resources.Limits[corev1.ResourceMemory] = *resource.NewQuantity(memory, resource.BinarySI)
+	}
+
+	if cpu := config.Host.Resources.NanoCPUs; cpu > 0 {
+		c.Resources.Limits[corev1.ResourceCPU] = *resource.NewMilliQuantity(cpu/1e6, resource.DecimalSI)
+	}
+
+	if config.Host.Privileged {
+		privileged := true
+		c.SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+	}
+
+	return c
+}
+
+func k8sServiceContainerName(index int, option *domain.DockerOption) string {
+	if util.HasString(option.Name) {
+		return k8sResourceName(option.Name)
+	}
+	return fmt.Sprintf("service-%d", index)
+}
+
+// k8sResourceName convert to dns-1123 label, the long name will be shorten with hash suffix
+func k8sResourceName(name string) string {
+	name = k8sInvalidNameChars.ReplaceAllString(strings.ToLower(name), "-")
+	name = strings.Trim(name, "-")
+
+	if len(name) > k8sMaxResourceName {
+		sum := sha1.Sum([]byte(name))
+		suffix := hex.EncodeToString(sum[:])[:7]
+		name = strings.Trim(name[:k8sMaxResourceName-len(suffix)-1], "-") + "-" + suffix
+	}
+
+	return name
+}
@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"github.com/flowci/flow-agent-x/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// newK8sClient create k8s client from service account that mounted in the agent pod, or from kubeconfig
+// if agent is not running in cluster, the namespace of client config is applied if it's not specified
+func newK8sClient(namespace string) (kubernetes.Interface, string, error) {
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{},
+	)
+
+	config, err := rest.InClusterConfig()
+	if err == rest.ErrNotInCluster {
+		config, err = loader.ClientConfig()
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	if util.IsEmptyString(namespace) {
+		if namespace, _, err = loader.Namespace(); err != nil {
+			return nil, "", err
+		}
+	}
+
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return client, namespace, nil
+}
+
+// k8sContainerStatus get status of container by name, nil if not reported yet
+func k8sContainerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	for i := range pod.Status.ContainerStatuses {
+		if pod.Status.ContainerStatuses[i].Name == name {
+			return &pod.Status.ContainerStatuses[i]
+		}
+	}
+	return nil
+}
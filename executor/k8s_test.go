@@ -0,0 +1,252 @@
+package executor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeK8sClient the step container of created pod is terminated with exit code, or running if exit code is nil
+func newFakeK8sClient(exitCode *int, onCreate func(pod *corev1.Pod)) *fake.Clientset {
+	client := fake.NewSimpleClientset()
+	client.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*corev1.Pod)
+
+		state := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+		if exitCode != nil {
+			state = corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: int32(*exitCode)}}
+		}
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: k8sStepContainer, State: state}}
+
+		if onCreate != nil {
+			onCreate(pod)
+		}
+		return false, nil, nil
+	})
+	return client
+}
+
+// k8sCreated get the created object of resource
+func k8sCreated(client *fake.Clientset, resource string) runtime.Object {
+	for _, action := range client.Actions() {
+		if action.Matches("create", resource) {
+			return action.(k8stesting.CreateAction).GetObject()
+		}
+	}
+	return nil
+}
+
+// k8sDeleted get delete options of the deleted resources by name
+func k8sDeleted(client *fake.Clientset, resource string) map[string]metav1.DeleteOptions {
+	deleted := make(map[string]metav1.DeleteOptions)
+	for _, action := range client.Actions() {
+		if action.Matches("delete", resource) {
+			impl := action.(k8stesting.DeleteActionImpl)
+			deleted[impl.Name] = impl.DeleteOptions
+		}
+	}
+	return deleted
+}
+
+func newK8sExecutor(assert *assert.Assertions, cmd *domain.ShellIn, client *fake.Clientset) *k8sExecutor {
+	ws, err := ioutil.TempDir("", "k8s_ws_")
+	assert.NoError(err)
+
+	executor := NewExecutor(Options{
+		K8s: &domain.K8sConfig{
+			Enabled:        true,
+			Namespace:      "flow-ci",
+			NodeName:       "node-1",
+			PodExecutor:    true,
+			WorkspaceClaim: "agent-ws",
+		},
+		Parent:    context.Background(),
+		Workspace: ws,
+		Cmd:       cmd,
+	})
+
+	k8s, ok := executor.(*k8sExecutor)
+	assert.True(ok)
+
+	k8s.client = client
+	k8s.namespace = "flow-ci"
+	return k8s
+}
+
+func TestShouldRunStepAsK8sPod(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := &domain.ShellIn{
+		ID:         "1-hello/world",
+		FlowId:     "flowid",
+		JobId:      "jobid",
+		Bash:       []string{"echo hello", "export FLOW_VVV=flowci"},
+		EnvFilters: []string{"FLOW_"},
+		Dockers: []*domain.DockerOption{
+			{Image: "ubuntu:18.04", IsRuntime: true, Memory: "1g", Cpus: 0.5},
+			{Image: "mysql:5.6", Name: "mysql", Environment: domain.Variables{"MYSQL_ROOT_PASSWORD": "12345"}},
+		},
+		Timeout: 60,
+	}
+
+	var executor *k8sExecutor
+	exitCode := 0
+
+	// simulate the script that write env file to shared workspace
+	client := newFakeK8sClient(&exitCode, func(pod *corev1.Pod) {
+		_ = ioutil.WriteFile(executor.envFile, []byte("FLOW_VVV=flowci\n"), 0644)
+	})
+
+	executor = newK8sExecutor(assert, cmd, client)
+	defer os.RemoveAll(executor.workspace)
+
+	assert.NoError(executor.Init())
+	go printLog(executor.Stdout())
+
+	assert.NoError(executor.Start())
+
+	// then: pod spec
+	pod := k8sCreated(client, "pods").(*corev1.Pod)
+	assert.Equal("flowci-step-1-hello-world-1", pod.Name)
+	assert.Equal("flow-ci", pod.Namespace)
+	assert.Equal("node-1", pod.Spec.NodeName)
+	assert.Equal("agent-ws", pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName)
+	assert.Equal(2, len(pod.Spec.Containers))
+
+	step := pod.Spec.Containers[0]
+	assert.Equal(k8sStepContainer, step.Name)
+	assert.Equal("ubuntu:18.04", step.Image)
+	assert.Equal(corev1.PullIfNotPresent, step.ImagePullPolicy)
+	assert.Equal(executor.jobDir, step.WorkingDir)
+	assert.Equal(executor.workspace, step.VolumeMounts[0].MountPath)
+	assert.Equal(int64(1073741824), step.Resources.Limits.Memory().Value())
+	assert.Equal("500m", step.Resources.Limits.Cpu().String())
+	assert.Equal([]string{linuxBash, "-c"}, step.Command[:2])
+	assert.Contains(step.Command[2], "echo hello")
+	assert.Contains(step.Command[2], "env > "+executor.envFile)
+
+	service := pod.Spec.Containers[1]
+	assert.Equal("mysql", service.Name)
+	assert.Equal([]corev1.EnvVar{{Name: "MYSQL_ROOT_PASSWORD", Value: "12345"}}, service.Env)
+
+	// then: result
+	result := executor.GetResult()
+	assert.Equal(domain.CmdStatusSuccess, result.Status)
+	assert.Equal("flowci", result.Output["FLOW_VVV"])
+	assert.Equal(int64(0), *k8sDeleted(client, "pods")[pod.Name].GracePeriodSeconds)
+}
+
+func TestShouldPassSecretVarsByK8sSecret(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := &domain.ShellIn{
+		ID:      "secret",
+		FlowId:  "flowid",
+		Bash:    []string{"echo $MY_TOKEN"},
+		Dockers: []*domain.DockerOption{{Image: "ubuntu:18.04"}},
+		Timeout: 60,
+	}
+
+	exitCode := 0
+	client := newFakeK8sClient(&exitCode, nil)
+
+	executor := newK8sExecutor(assert, cmd, client)
+	defer os.RemoveAll(executor.workspace)
+	executor.secretVars = domain.Variables{"MY_TOKEN": "s3cr3t"}
+	executor.configVars = domain.Variables{"MY_CONFIG": "config"}
+
+	assert.NoError(executor.Init())
+	go printLog(executor.Stdout())
+
+	assert.NoError(executor.Start())
+
+	// then: secret created with the same name of pod
+	pod := k8sCreated(client, "pods").(*corev1.Pod)
+	secret := k8sCreated(client, "secrets").(*corev1.Secret)
+	assert.Equal(pod.Name, secret.Name)
+	assert.Equal(corev1.SecretTypeOpaque, secret.Type)
+	assert.Equal("s3cr3t", secret.StringData["MY_TOKEN"])
+
+	// then: secret value not in the pod spec
+	step := pod.Spec.Containers[0]
+	for _, env := range step.Env {
+		assert.NotEqual("MY_TOKEN", env.Name)
+		assert.NotEqual("s3cr3t", env.Value)
+	}
+	assert.Contains(step.Env, corev1.EnvVar{Name: "MY_CONFIG", Value: "config"})
+	assert.NotContains(strings.Join(step.Command, " "), "s3cr3t")
+	assert.Contains(step.Command[2], "unset MY_TOKEN")
+	assert.Equal(pod.Name, step.EnvFrom[0].SecretRef.Name)
+
+	// then: secret deleted with the pod
+	assert.Contains(k8sDeleted(client, "secrets"), pod.Name)
+}
+
+func TestShouldDeletePodOnTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := &domain.ShellIn{
+		ID:          "timeout",
+		FlowId:      "flowid",
+		Bash:        []string{"sleep 9999"},
+		Dockers:     []*domain.DockerOption{{Image: "ubuntu:18.04"}},
+		Timeout:     1,
+		GracePeriod: 3,
+	}
+
+	client := newFakeK8sClient(nil, nil)
+	executor := newK8sExecutor(assert, cmd, client)
+	defer os.RemoveAll(executor.workspace)
+
+	assert.NoError(executor.Init())
+	go printLog(executor.Stdout())
+
+	start := time.Now()
+	assert.NoError(executor.Start())
+	assert.True(time.Since(start) < 5*time.Second)
+
+	assert.Equal(domain.CmdStatusTimeout, executor.GetResult().Status)
+	pod := k8sCreated(client, "pods").(*corev1.Pod)
+	assert.Equal(int64(3), *k8sDeleted(client, "pods")[pod.Name].GracePeriodSeconds)
+}
+
+func TestShouldRejectHealthCheckInK8sPod(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := &domain.ShellIn{
+		ID: "step-1",
+		Dockers: []*domain.DockerOption{
+			{Image: "ubuntu:18.04", IsRuntime: true},
+			{Image: "mysql:5.7", HealthCheck: &domain.DockerHealthCheck{Port: 3306}},
+		},
+		Bash:    []string{"echo hello"},
+		Timeout: 10,
+	}
+
+	executor := newK8sExecutor(assert, cmd, newFakeK8sClient(nil, nil))
+	defer os.RemoveAll(executor.workspace)
+
+	err := executor.Init()
+	assert.Error(err)
+	assert.Contains(err.Error(), "health check of docker 'mysql:5.7'")
+}
+
+func TestShouldConvertToK8sResourceName(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("flow-ci-test", k8sResourceName("Flow_CI/test-"))
+
+	name := k8sResourceName(strings.Repeat("a", 100))
+	assert.Equal(k8sMaxResourceName, len(name))
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+// +build !windows
+
+package executor
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+const processCheckInterval = 100 * time.Millisecond
+
+// setProcessGroup run the cmd in its own process group, so the children can be killed with it
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup send SIGTERM to the process group, and SIGKILL if any process still alive after grace period
+func killProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+
+	pgid := -cmd.Process.Pid
+	if err := syscall.Kill(pgid, syscall.SIGTERM); err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(grace)
+	for time.Now().Before(deadline) {
+		if err := syscall.Kill(pgid, 0); err != nil {
+			return
+		}
+		time.Sleep(processCheckInterval)
+	}
+
+	_ = syscall.Kill(pgid, syscall.SIGKILL)
+}
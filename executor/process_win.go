@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package executor
+
+import (
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// setProcessGroup not required on windows, the process tree killed by taskkill
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// killProcessGroup kill the process tree by taskkill, since no SIGTERM on windows the grace period is ignored
+func killProcessGroup(cmd *exec.Cmd, grace time.Duration) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+
+	err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run()
+	if err != nil {
+		_ = cmd.Process.Kill()
+	}
+}
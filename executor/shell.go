@@ -10,6 +10,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 )
 
@@ -20,6 +21,9 @@ type (
 		tty     *exec.Cmd
 		binDir  string
 		envFile string
+
+		// killDone closed when the context watcher of current attempt is finished, include killing processes
+		killDone <-chan struct{}
 	}
 )
 
@@ -48,43 +52,28 @@ func (se *shellExecutor) Init() (out error) {
 	}
 
 	// setup job dir under workspace
-	se.jobDir = filepath.Join(se.workspace, util.ParseString(se.inCmd.FlowId))
+	se.jobDir = se.prepareJobDir(se.workspace)
 	se.vars[domain.VarAgentJobDir] = se.jobDir
-
-	err = os.MkdirAll(se.jobDir, os.ModePerm)
-	util.PanicIfErr(err)
+	se.checkout(se.jobDir)
+	se.initPluginDirVar(se.pluginDir, string(filepath.Separator))
 
 	se.vars.Resolve()
-	se.copyCache()
+	se.loadCache(se.hashFilesInJobDir)
+	se.moveCacheToJobDir()
 	return nil
 }
 
 func (se *shellExecutor) Start() (out error) {
-	// handle context error
-	go func() {
-		<-se.context.Done()
-		err := se.context.Err()
-
-		if err != nil {
-			se.handleErrors(err)
-		}
-	}()
-
-	for i := se.inCmd.Retry; i >= 0; i-- {
-		out = se.doStart()
-		r := se.result
+	out = se.runWithRetry(func() error {
+		done := make(chan struct{})
+		defer close(done)
 
-		if r.Status == domain.CmdStatusException || out != nil {
-			if i > 0 {
-				se.writeSingleLog(">>>>>>> retry >>>>>>>")
-			}
-			continue
-		}
-
-		break
-	}
+		se.killDone = se.watchContext(se.context, done)
+		return se.doStart()
+	})
 
-	se.writeCache()
+	se.copyCacheFromJobDir()
+	se.releaseJobDir(se.workspace)
 	return
 }
 
@@ -98,78 +87,39 @@ func (se *shellExecutor) StopTty() {
 //	private
 //====================================================================
 
-// copy cache to job dir if cache defined in cacheSrcDir
-func (se *shellExecutor) copyCache() {
-	if !util.HasString(se.cacheInputDir) {
-		return
+// applyResourceLimit put the process into a dedicated cgroup, return nil if no limit or not applied
+func (se *shellExecutor) applyResourceLimit(pid int) *cgroup {
+	if !se.resources.HasLimit() {
+		return nil
 	}
 
-	files, err := ioutil.ReadDir(se.cacheInputDir)
-	util.PanicIfErr(err)
-
-	for _, f := range files {
-		oldPath := filepath.Join(se.cacheInputDir, f.Name())
-		newPath := filepath.Join(se.jobDir, f.Name())
-
-		if util.IsFileExists(newPath) {
-			_ = os.Remove(newPath)
-		}
-
-		// move cache from src dir to job dir
-		err = os.Rename(oldPath, newPath)
+	name := "step-" + strings.ReplaceAll(se.inCmd.ID, "/", "_")
+	cg, err := newCgroup(se.cgroupParent, name, se.resources)
+	if err == nil {
+		err = cg.addProcess(pid)
+	}
 
-		if err == nil {
-			se.writeSingleLog(fmt.Sprintf("cache %s has been applied", f.Name()))
-		} else {
-			se.writeSingleLog(fmt.Sprintf("cache %s not applied: %s", f.Name(), err.Error()))
+	if err != nil {
+		if cg != nil {
+			cg.remove()
 		}
 
-		// remove cache from cache dir anyway
-		_ = os.RemoveAll(oldPath)
+		se.writeSingleLog(fmt.Sprintf("resource limits (%s) not applied: %s", se.resources, err.Error()))
+		return nil
 	}
+
+	se.writeSingleLog(fmt.Sprintf("resource limits (%s) applied", se.resources))
+	return cg
 }
 
-// write cache back to cacheSrcDir
-func (se *shellExecutor) writeCache() {
-	if !se.inCmd.HasCache() {
+// toOOMKilledIfFailed mark failure reason as oom killed
+func (se *shellExecutor) toOOMKilledIfFailed(cg *cgroup) {
+	if cg == nil || se.result.Status != domain.CmdStatusException {
 		return
 	}
 
-	defer util.RecoverPanic(func(e error) {
-		util.LogWarn(e.Error())
-	})
-
-	dir, err := ioutil.TempDir("", "_cache_output_")
-	if err != nil {
-		util.LogWarn(err.Error())
-		return
-	}
-
-	se.cacheOutputDir = dir
-	cache := se.inCmd.Cache
-
-	for _, path := range cache.Paths {
-		path = filepath.Clean(path)
-		fullPath := filepath.Join(se.jobDir, path)
-
-		info, exist := util.IsFileExistsAndReturnFileInfo(fullPath)
-		if !exist {
-			continue
-		}
-
-		newPath := filepath.Join(dir, path)
-
-		if info.IsDir() {
-			err := util.CopyDir(fullPath, newPath)
-			util.PanicIfErr(err)
-
-			util.LogDebug("dir %s write back to cache dir", newPath)
-			continue
-		}
-
-		err := util.CopyFile(fullPath, newPath)
-		util.PanicIfErr(err)
-		util.LogDebug("file %s write back to cache dir", newPath)
+	if cg.isOOMKilled() {
+		se.result.Error = fmt.Sprintf("%s: memory limit %dMB exceeded", domain.CmdErrorOOMKilled, se.resources.Memory)
 	}
 }
 
@@ -187,30 +137,54 @@ func (se *shellExecutor) exportEnv() {
 	se.result.Output = readEnvFromReader(se.os, file, se.inCmd.EnvFilters)
 }
 
-func (se *shellExecutor) handleErrors(err error) {
-	kill := func() {
-		if se.command != nil {
-			_ = se.command.Process.Kill()
-		}
+// handle context error of the attempt until it's done, the returned channel is closed when the handling finished
+func (se *shellExecutor) watchContext(ctx context.Context, done <-chan struct{}) <-chan struct{} {
+	finished := make(chan struct{})
 
-		if se.tty != nil {
-			_ = se.tty.Process.Kill()
+	go func() {
+		defer close(finished)
+
+		select {
+		case <-ctx.Done():
+			if err := ctx.Err(); err != nil {
+				se.handleErrors(err)
+			}
+		case <-done:
 		}
+	}()
+
+	return finished
+}
+
+// removeCgroup delete the cgroup after the process group been killed within grace period if context is done
+func (se *shellExecutor) removeCgroup(cg *cgroup) {
+	if se.context.Err() != nil && se.killDone != nil {
+		<-se.killDone
+	}
+
+	cg.remove()
+}
+
+func (se *shellExecutor) handleErrors(err error) {
+	kill := func() {
+		grace := se.inCmd.GetGracePeriod()
+		go killProcessGroup(se.tty, grace)
+		killProcessGroup(se.command, grace)
 	}
 
 	util.LogWarn("handleError on shell: %s", err.Error())
 
 	if err == context.DeadlineExceeded {
 		util.LogDebug("Timeout..")
-		kill()
 		se.toTimeOutStatus()
+		kill()
 		return
 	}
 
 	if err == context.Canceled {
 		util.LogDebug("Cancel..")
-		kill()
 		se.toKilledStatus()
+		kill()
 		return
 	}
 
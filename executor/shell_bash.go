@@ -27,6 +27,7 @@ func (se *shellExecutor) doStart() (out error) {
 
 	command := exec.Command(linuxBash)
 	command.Dir = se.jobDir
+	setProcessGroup(command)
 	command.Env = append(os.Environ(), se.vars.ToStringArray()...)
 	command.Env = append(command.Env, se.secretVars.ToStringArray()...)
 	command.Env = append(command.Env, se.configVars.ToStringArray()...)
@@ -53,6 +54,12 @@ func (se *shellExecutor) doStart() (out error) {
 		return se.toErrorStatus(err)
 	}
 
+	// limit resources before script written, so all children are in the cgroup
+	cg := se.applyResourceLimit(command.Process.Pid)
+	if cg != nil {
+		defer se.removeCgroup(cg)
+	}
+
 	se.writeLog(stdout, true, true)
 	se.writeLog(stderr, true, true)
 	se.writeCmd(stdin, se.setupBin, se.writeEnv, func(script string) string {
@@ -78,6 +85,7 @@ func (se *shellExecutor) doStart() (out error) {
 
 	// to finish status
 	se.toFinishStatus(getExitCode(command))
+	se.toOOMKilledIfFailed(cg)
 	return se.context.Err()
 }
 
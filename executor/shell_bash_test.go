@@ -4,10 +4,15 @@
 package executor
 
 import (
+	"context"
 	"encoding/base64"
+	"fmt"
 	"github.com/flowci/flow-agent-x/domain"
 	"github.com/flowci/flow-agent-x/util"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -33,6 +38,47 @@ func TestShouldExecWithErrorInBash(t *testing.T) {
 	shouldExecWithError(assert, cmd)
 }
 
+func TestShouldRetryWithPolicyInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	cmd := createBashTestCmd()
+	cmd.Retry = 2
+	cmd.RetryPolicy = &domain.RetryPolicy{
+		Delay:     1,
+		ExitCodes: []int{3},
+	}
+	cmd.Bash = []string{"exit 3"}
+
+	executor := newExecutor(cmd, false)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.Stdout())
+	assert.NoError(executor.Start())
+
+	result := executor.GetResult()
+	assert.Equal(domain.CmdStatusException, result.Status)
+	assert.Equal(3, result.Code)
+	assert.Equal(3, len(result.Attempts))
+	assert.Equal(3, result.Attempts[2].Attempt)
+	assert.Equal(3, result.Attempts[2].Code)
+
+	// should not retry since exit code not matched
+	cmd = createBashTestCmd()
+	cmd.Retry = 2
+	cmd.RetryPolicy = &domain.RetryPolicy{ExitCodes: []int{3}}
+	cmd.Bash = []string{"exit 1"}
+
+	executor = newExecutor(cmd, false)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.Stdout())
+	assert.NoError(executor.Start())
+
+	result = executor.GetResult()
+	assert.Equal(1, result.Code)
+	assert.Equal(1, len(result.Attempts))
+}
+
 func TestShouldExecWithErrorButAllowFailureInBash(t *testing.T) {
 	assert := assert.New(t)
 	cmd := createBashTestCmd()
@@ -51,6 +97,68 @@ func TestShouldExitByKillInBash(t *testing.T) {
 	shouldExecButKilled(assert, cmd)
 }
 
+func TestShouldKillChildProcessesOnTimeoutInBash(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_kill_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	aliveFile := filepath.Join(dir, "alive")
+
+	cmd := createBashTestCmd()
+	cmd.Timeout = 2
+	cmd.GracePeriod = 1
+	cmd.Bash = []string{
+		fmt.Sprintf("(sleep 4 && touch %s) &", aliveFile),
+		"sleep 9999",
+	}
+
+	executor := newExecutor(cmd, false)
+	assert.NoError(executor.Init())
+
+	go printLog(executor.Stdout())
+	assert.NoError(executor.Start())
+
+	result := executor.GetResult()
+	assert.Equal(domain.CmdStatusTimeout, result.Status)
+
+	// background process should be killed with the shell
+	time.Sleep(4 * time.Second)
+	assert.False(util.IsFileExists(aliveFile))
+}
+
+func TestShouldLoadCacheAfterJobDirReady(t *testing.T) {
+	assert := assert.New(t)
+
+	cacheDir, err := ioutil.TempDir("", "test_cache_")
+	assert.NoError(err)
+	defer os.RemoveAll(cacheDir)
+	assert.NoError(ioutil.WriteFile(filepath.Join(cacheDir, "go.sum"), []byte("sum"), 0644))
+
+	called := 0
+	executor := NewExecutor(Options{
+		Parent: context.Background(),
+		Cmd:    createBashTestCmd(),
+		CacheLoader: func(hashFiles HashFilesFunc) string {
+			called++
+
+			sum, err := hashFiles([]string{"**/go.sum"})
+			assert.NoError(err)
+			assert.Empty(sum)
+			return cacheDir
+		},
+	}).(*shellExecutor)
+
+	assert.NoError(executor.Init())
+	assert.Equal(1, called)
+	assert.True(util.IsFileExists(filepath.Join(executor.jobDir, "go.sum")))
+
+	sum, err := executor.hashFilesInJobDir([]string{"**/go.sum"})
+	assert.NoError(err)
+	assert.Len(sum, 64)
+}
+
 func TestShouldStartBashInteract(t *testing.T) {
 	assert := assert.New(t)
 
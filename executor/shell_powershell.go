@@ -34,6 +34,7 @@ func (se *shellExecutor) doStart() (out error) {
 
 	command := exec.Command(path, "-NoLogo", "-NoProfile", "-NonInteractive", "-File", ps1File)
 	command.Dir = se.jobDir
+	setProcessGroup(command)
 	command.Env = append(os.Environ(), se.vars.ToStringArray()...)
 	command.Env = append(command.Env, se.secretVars.ToStringArray()...)
 	command.Env = append(command.Env, se.configVars.ToStringArray()...)
@@ -51,16 +52,6 @@ func (se *shellExecutor) doStart() (out error) {
 		_ = stderr.Close()
 	}()
 
-	// handle context error
-	go func() {
-		<-se.context.Done()
-		err := se.context.Err()
-
-		if err != nil {
-			se.handleErrors(err)
-		}
-	}()
-
 	// start command
 	if err := command.Start(); err != nil {
 		return se.toErrorStatus(err)
@@ -6,13 +6,26 @@ import (
 	"github.com/dustin/go-humanize"
 	"github.com/flowci/flow-agent-x/api"
 	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/executor"
 	"github.com/flowci/flow-agent-x/util"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	// cacheHashFilesPattern match ${hashFiles('pattern', ...)} in cache keys
+	cacheHashFilesPattern = regexp.MustCompile(`\$\{\s*hashFiles\(([^)]*)\)\s*}`)
+
+	// cacheFormatPreference archive formats in order of preference
+	cacheFormatPreference = []string{util.ArchiveFormatTarZstd, util.ArchiveFormatTarGzip, util.ArchiveFormatZip}
 )
 
 type CacheManager struct {
 	client api.Client
+	store  *CacheStore // local store of cache archives, nil if disabled
 }
 
 type progressWriter struct {
@@ -21,15 +34,31 @@ type progressWriter struct {
 	cmdIn  *domain.ShellIn
 }
 
-// Download download cache into a temp dir and return
-func (cm *CacheManager) Download(cmdIn *domain.ShellIn) string {
+// Download download cache into a temp dir and return, the archive in local store is used if its sha256 matched with server.
+// The hashFiles in keys is evaluated by the func from executor
+func (cm *CacheManager) Download(cmdIn *domain.ShellIn, hashFiles executor.HashFilesFunc) string {
 	defer util.RecoverPanic(func(e error) {
 		util.LogWarn(e.Error())
 	})
 
-	cm.Resolve(cmdIn)
+	cm.Resolve(cmdIn, hashFiles)
+
+	if cmdIn.Cache.Unresolved {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s not restored since key is not resolved", cmdIn.Cache.Key))
+		return ""
+	}
+
+	cache := cm.find(cmdIn)
+	if cache == nil {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s not found", cmdIn.Cache.Key))
+		return ""
+	}
+
+	cmdIn.Cache.HitKey = cache.Key
+	if cache.Key != cmdIn.Cache.Key {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s not found, restore from %s", cmdIn.Cache.Key, cache.Key))
+	}
 
-	cache := cm.client.CacheGet(cmdIn.JobId, cmdIn.Cache.Key)
 	sendLog(cm.client, cmdIn, fmt.Sprintf("Start to download cache %s", cache.Key))
 
 	writer := &progressWriter{
@@ -40,9 +69,35 @@ func (cm *CacheManager) Download(cmdIn *domain.ShellIn) string {
 	cacheDir, err := ioutil.TempDir("", "cache_")
 	util.PanicIfErr(err)
 
+	archiveDir, err := ioutil.TempDir("", "cache_archives_")
+	util.PanicIfErr(err)
+	defer os.RemoveAll(archiveDir)
+
 	for _, file := range cache.Files {
+		if archive, ok := cm.lookup(cache, file); ok {
+			sendLog(cm.client, cmdIn, fmt.Sprintf("---> cache %s from local store", file))
+			util.LogIfError(api.ExtractCache(archive, cacheDir, file))
+			continue
+		}
+
 		sendLog(cm.client, cmdIn, fmt.Sprintf("---> cache %s", file))
-		cm.client.CacheDownload(cache.Id, cacheDir, file, writer)
+
+		archive := filepath.Join(archiveDir, file)
+		if err = cm.client.CacheDownload(cache.Id, file, archive, writer); err != nil {
+			util.LogWarn(err.Error())
+			continue
+		}
+
+		util.LogIfError(api.ExtractCache(archive, cacheDir, file))
+		cm.save(file, archive)
+	}
+
+	cm.evict()
+
+	if manifest, err := cacheManifest(cacheDir); err == nil {
+		cmdIn.Cache.Manifest = manifest
+	} else {
+		util.LogWarn("Unable to build manifest of cache %s: %s", cache.Key, err.Error())
 	}
 
 	sendLog(cm.client, cmdIn, "All cached files downloaded")
@@ -50,8 +105,13 @@ func (cm *CacheManager) Download(cmdIn *domain.ShellIn) string {
 	return cacheDir
 }
 
-// Upload upload all files/dirs from cache dir
+// Upload upload all files/dirs from cache dir, and save the archives to local store
 func (cm *CacheManager) Upload(cmdIn *domain.ShellIn, cacheDir string) {
+	if cmdIn.Cache.Unresolved || cacheHashFilesPattern.MatchString(cmdIn.Cache.Key) {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s not uploaded since key is not resolved", cmdIn.Cache.Key))
+		return
+	}
+
 	fileInfos, err := ioutil.ReadDir(cacheDir)
 	if err != nil {
 		util.LogWarn(err.Error())
@@ -62,32 +122,205 @@ func (cm *CacheManager) Upload(cmdIn *domain.ShellIn, cacheDir string) {
 		return
 	}
 
+	if !cm.shouldUpload(cmdIn, cacheDir) {
+		return
+	}
+
 	files := make([]string, len(fileInfos))
 	for i, fileInfo := range fileInfos {
 		files[i] = filepath.Join(cacheDir, fileInfo.Name())
 	}
 
-	sendLog(cm.client, cmdIn, fmt.Sprintf("Start to upload cache %s", cmdIn.Cache.Key))
+	archiveDir, err := ioutil.TempDir("", "cache_archives_")
+	if err != nil {
+		util.LogWarn(err.Error())
+		return
+	}
+	defer os.RemoveAll(archiveDir)
+
+	format := negotiateCacheFormat(cm.client.CacheFormats())
+	sendLog(cm.client, cmdIn, fmt.Sprintf("Start to upload cache %s in %s", cmdIn.Cache.Key, format))
+
+	archives := api.ArchiveCache(cacheDir, files, archiveDir, format)
 
-	err = cm.client.CachePut(cmdIn.JobId, cmdIn.Cache.Key, cacheDir, files)
+	_, err = cm.client.CachePut(cmdIn.JobId, cmdIn.Cache.Key, format, archives)
 	if err != nil {
 		sendLog(cm.client, cmdIn, fmt.Sprintf("Unable to cache %s : %s", cmdIn.Cache.Key, err.Error()))
 		return
 	}
 
 	sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s uploaded", cmdIn.Cache.Key))
+
+	for _, archive := range archives {
+		cm.save(filepath.Base(archive), archive)
+	}
+
+	cm.evict()
+}
+
+// shouldUpload upload only if cache not restored, restored from other key or content changed
+func (cm *CacheManager) shouldUpload(cmdIn *domain.ShellIn, cacheDir string) bool {
+	cache := cmdIn.Cache
+
+	if util.IsEmptyString(cache.HitKey) {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s was not restored, upload", cache.Key))
+		return true
+	}
+
+	if cache.HitKey != cache.Key {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s was restored from %s, upload", cache.Key, cache.HitKey))
+		return true
+	}
+
+	manifest, err := cacheManifest(cacheDir)
+	if err != nil {
+		util.LogWarn("Unable to build manifest of cache %s: %s", cache.Key, err.Error())
+		return true
+	}
+
+	if util.IsEmptyString(cache.Manifest) || manifest != cache.Manifest {
+		sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s was changed, upload", cache.Key))
+		return true
+	}
+
+	sendLog(cm.client, cmdIn, fmt.Sprintf("Cache %s is unchanged, upload skipped", cache.Key))
+	return false
+}
+
+// find get cache by the exact key, then by restore keys in order with prefix matching
+func (cm *CacheManager) find(cmdIn *domain.ShellIn) *domain.JobCache {
+	jobId := cmdIn.JobId
+
+	if cache := tryGetCache(func() *domain.JobCache { return cm.client.CacheGet(jobId, cmdIn.Cache.Key) }); cache != nil {
+		return cache
+	}
+
+	for _, prefix := range cmdIn.Cache.RestoreKeys {
+		if util.IsEmptyString(prefix) {
+			continue
+		}
+
+		if cache := tryGetCache(func() *domain.JobCache { return cm.client.CacheFind(jobId, prefix) }); cache != nil {
+			return cache
+		}
+	}
+
+	return nil
+}
+
+// tryGetCache get cache from server, nil if not found
+func tryGetCache(get func() *domain.JobCache) (cache *domain.JobCache) {
+	defer util.RecoverPanic(func(e error) {
+		util.LogDebug("cache not found: %s", e.Error())
+		cache = nil
+	})
+
+	return get()
+}
+
+// negotiateCacheFormat get the preferred archive format supported by server, zip is the legacy fallback
+func negotiateCacheFormat(supported []string) string {
+	for _, format := range cacheFormatPreference {
+		for _, s := range supported {
+			if s == format {
+				return format
+			}
+		}
+	}
+	return util.ArchiveFormatZip
+}
+
+func (cm *CacheManager) lookup(cache *domain.JobCache, file string) (string, bool) {
+	if cm.store == nil {
+		return "", false
+	}
+	return cm.store.Lookup(cache, file)
+}
+
+func (cm *CacheManager) save(file, archive string) {
+	if cm.store == nil {
+		return
+	}
+
+	if _, err := cm.store.Add(archive); err != nil {
+		util.LogWarn("Unable to save cache %s to local store: %s", file, err.Error())
+	}
+}
+
+func (cm *CacheManager) evict() {
+	if cm.store == nil {
+		return
+	}
+
+	if removed := cm.store.Evict(); len(removed) > 0 {
+		util.LogInfo("%d archives evicted from local cache store", len(removed))
+	}
 }
 
-// Resolve resolve env vars in cache key and paths
-func (cm *CacheManager) Resolve(cmdIn *domain.ShellIn) {
+// Resolve resolve env vars and hashFiles in cache key, and env vars in paths, the cache is marked as unresolved
+// if hashFiles in key cannot be resolved, and the restore keys that cannot be resolved are ignored
+func (cm *CacheManager) Resolve(cmdIn *domain.ShellIn, hashFiles executor.HashFilesFunc) {
 	cache := cmdIn.Cache
-	cache.Key = util.ParseStringWithSource(cache.Key, cmdIn.Inputs)
+
+	key, ok := cm.resolveKey(cmdIn, cache.Key, hashFiles)
+	if ok {
+		cache.Key = key
+	}
+	cache.Unresolved = !ok
+
+	for i, restoreKey := range cache.RestoreKeys {
+		if key, ok = cm.resolveKey(cmdIn, restoreKey, hashFiles); !ok {
+			key = ""
+		}
+		cache.RestoreKeys[i] = key
+	}
 
 	for i, p := range cache.Paths {
 		cache.Paths[i] = util.ParseStringWithSource(p, cmdIn.Inputs)
 	}
 }
 
+// resolveKey replace ${hashFiles('pattern', ...)} with sha256 of matched files, then the env vars are replaced,
+// return false if hashFiles is not available or failed
+func (cm *CacheManager) resolveKey(cmdIn *domain.ShellIn, key string, hashFiles executor.HashFilesFunc) (string, bool) {
+	resolved := true
+
+	key = cacheHashFilesPattern.ReplaceAllStringFunc(key, func(expr string) string {
+		patterns := parseHashFilesArgs(cacheHashFilesPattern.FindStringSubmatch(expr)[1])
+		if hashFiles == nil || len(patterns) == 0 {
+			sendLog(cm.client, cmdIn, fmt.Sprintf("Unable to resolve %s", expr))
+			resolved = false
+			return expr
+		}
+
+		sum, err := hashFiles(patterns)
+		if err != nil {
+			sendLog(cm.client, cmdIn, fmt.Sprintf("Unable to resolve %s: %s", expr, err.Error()))
+			resolved = false
+			return expr
+		}
+
+		return sum
+	})
+
+	if !resolved {
+		return key, false
+	}
+
+	return util.ParseStringWithSource(key, cmdIn.Inputs), true
+}
+
+// parseHashFilesArgs parse quoted patterns separated by comma
+func parseHashFilesArgs(args string) (patterns []string) {
+	for _, arg := range strings.Split(args, ",") {
+		arg = strings.Trim(strings.TrimSpace(arg), `'"`)
+		if util.HasString(arg) {
+			patterns = append(patterns, arg)
+		}
+	}
+	return
+}
+
 func (pw *progressWriter) Write(p []byte) (int, error) {
 	n := len(p)
 	pw.total += uint64(n)
@@ -0,0 +1,54 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cacheManifest sha256 over path, type, mode, size and mtime of all entries in the cache dir, the entries
+// are walked in lexical order. The mtime of dir is ignored since it's changed by adding or removing entries,
+// which are covered by the entries themselves
+func cacheManifest(dir string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		mode := info.Mode()
+
+		switch {
+		case mode.IsDir():
+			_, _ = fmt.Fprintf(hash, "d %s %o\n", rel, mode.Perm())
+
+		case mode&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(hash, "l %s %s\n", rel, link)
+
+		default:
+			// mtime in seconds, since the sub-second is dropped by tar and zip
+			_, _ = fmt.Fprintf(hash, "f %s %o %d %d\n", rel, mode.Perm(), info.Size(), info.ModTime().Unix())
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
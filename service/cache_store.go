@@ -0,0 +1,139 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+)
+
+const (
+	cacheStoreBlobDir     = "blobs"
+	cacheStoreLockFile    = ".lock"
+	cacheStoreLockTimeout = time.Minute
+)
+
+var (
+	cacheSha256Regex = regexp.MustCompile("^[0-9a-fA-F]{64}$")
+)
+
+type (
+	// CacheStore local content addressed store of cache archives on the agent, the archive is saved to
+	// 'blobs/<sha256>' and looked up by the sha256 from server.
+	// The least recently used blobs are evicted if the total size is over the max size
+	CacheStore struct {
+		dir     string
+		maxSize int64 // in bytes
+	}
+)
+
+// Lookup get archive of the cache file from the store by the sha256 from server, since the cache could be
+// uploaded again with the same id by other agents. The blob is marked as recently used
+func (s *CacheStore) Lookup(cache *domain.JobCache, file string) (string, bool) {
+	sum, ok := cache.Checksums[file]
+	if !ok || !cacheSha256Regex.MatchString(sum) {
+		return "", false
+	}
+
+	blob := s.blobPath(strings.ToLower(sum))
+	if !util.IsFileExists(blob) {
+		return "", false
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(blob, now, now)
+	return blob, true
+}
+
+// Add move the archive to the store by its sha256, return the path of blob
+func (s *CacheStore) Add(archive string) (string, error) {
+	sum, err := util.FileSha256(archive)
+	if err != nil {
+		return "", err
+	}
+
+	lock, err := util.LockFile(filepath.Join(s.dir, cacheStoreLockFile), cacheStoreLockTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer lock.Unlock()
+
+	blob := s.blobPath(sum)
+	if !util.IsFileExists(blob) {
+		if err = moveFile(archive, blob); err != nil {
+			return "", err
+		}
+	}
+
+	now := time.Now()
+	_ = os.Chtimes(blob, now, now)
+	return blob, nil
+}
+
+// Evict remove the least recently used blobs until the total size is under max size, return the sha256 of removed blobs
+func (s *CacheStore) Evict() (removed []string) {
+	defer util.RecoverPanic(func(e error) {
+		util.LogWarn("Unable to evict local cache: %s", e.Error())
+	})
+
+	lock, err := util.LockFile(filepath.Join(s.dir, cacheStoreLockFile), cacheStoreLockTimeout)
+	util.PanicIfErr(err)
+	defer lock.Unlock()
+
+	blobs, err := ioutil.ReadDir(filepath.Join(s.dir, cacheStoreBlobDir))
+	util.PanicIfErr(err)
+
+	total := int64(0)
+	for _, blob := range blobs {
+		total += blob.Size()
+	}
+
+	sort.Slice(blobs, func(i, j int) bool {
+		return blobs[i].ModTime().Before(blobs[j].ModTime())
+	})
+
+	for _, blob := range blobs {
+		if total <= s.maxSize {
+			break
+		}
+
+		if err = os.Remove(s.blobPath(blob.Name())); err != nil {
+			util.LogWarn("Unable to remove local cache blob %s: %s", blob.Name(), err.Error())
+			continue
+		}
+
+		total -= blob.Size()
+		removed = append(removed, blob.Name())
+	}
+
+	return
+}
+
+func (s *CacheStore) blobPath(sum string) string {
+	return filepath.Join(s.dir, cacheStoreBlobDir, sum)
+}
+
+// moveFile rename the file, or copy and remove it if rename is not supported, ex: across devices
+func moveFile(src, dst string) error {
+	if os.Rename(src, dst) == nil {
+		return nil
+	}
+
+	tmp := dst + ".tmp"
+	if err := util.CopyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
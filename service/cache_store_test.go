@@ -0,0 +1,322 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/api"
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// cacheClient fake client with server side caches in memory
+type cacheClient struct {
+	api.Client
+	formats   []string
+	caches    map[string]*domain.JobCache
+	files     map[string][]byte
+	downloads int
+	puts      int
+	checksums bool // provide sha256 of archives
+}
+
+func (c *cacheClient) CacheFormats() []string {
+	return c.formats
+}
+
+func (c *cacheClient) CachePut(jobId, key, format string, archives []string) (*domain.JobCache, error) {
+	c.puts++
+	cache := &domain.JobCache{Id: "cache" + key, Key: key}
+	for _, archive := range archives {
+		raw, err := ioutil.ReadFile(archive)
+		if err != nil {
+			return nil, err
+		}
+
+		name := filepath.Base(archive)
+		cache.Files = append(cache.Files, name)
+		c.files[cache.Id+"/"+name] = raw
+
+		if c.checksums {
+			if cache.Checksums == nil {
+				cache.Checksums = map[string]string{}
+			}
+			cache.Checksums[name] = sha256Of(raw)
+		}
+	}
+
+	c.caches[key] = cache
+	return cache, nil
+}
+
+func (c *cacheClient) CacheGet(jobId, key string) *domain.JobCache {
+	return c.caches[key]
+}
+
+func (c *cacheClient) CacheFind(jobId, prefix string) *domain.JobCache {
+	for key, cache := range c.caches {
+		if strings.HasPrefix(key, prefix) {
+			return cache
+		}
+	}
+	return nil
+}
+
+func (c *cacheClient) CacheDownload(cacheId, file, dest string, progress io.Writer) error {
+	c.downloads++
+	return ioutil.WriteFile(dest, c.files[cacheId+"/"+file], 0644)
+}
+
+func (c *cacheClient) SendShellLog(jobId, stepId, b64Log string) {
+}
+
+func TestShouldRestoreCacheFromLocalStore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_cache_store_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	client := &cacheClient{
+		formats:   []string{util.ArchiveFormatZip, util.ArchiveFormatTarZstd},
+		caches:    map[string]*domain.JobCache{},
+		files:     map[string][]byte{},
+		checksums: true,
+	}
+	manager := &CacheManager{client: client, store: NewCacheStore(filepath.Join(dir, "store"), 10)}
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(os.MkdirAll(filepath.Join(src, "deps"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "deps", "a.txt"), []byte("a"), 0644))
+
+	in := &domain.ShellIn{Cache: &domain.Cache{Key: "deps"}}
+	manager.Upload(in, src)
+
+	// restored from local store after uploaded
+	out := manager.Download(in, nil)
+	defer os.RemoveAll(out)
+
+	content, err := ioutil.ReadFile(filepath.Join(out, "deps", "a.txt"))
+	assert.NoError(err)
+	assert.Equal("a", string(content))
+	assert.Equal(0, client.downloads)
+
+	// download from server if it's not in local store
+	manager.store = NewCacheStore(filepath.Join(dir, "other"), 10)
+	out = manager.Download(in, nil)
+	defer os.RemoveAll(out)
+
+	assert.True(util.IsFileExists(filepath.Join(out, "deps", "a.txt")))
+	assert.Equal(1, client.downloads)
+
+	out = manager.Download(in, nil)
+	defer os.RemoveAll(out)
+	assert.Equal(1, client.downloads)
+
+	// download from server if cache uploaded again with the same id by other agent
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "deps", "a.txt"), []byte("b"), 0644))
+	other := &CacheManager{client: client, store: NewCacheStore(filepath.Join(dir, "agent-b"), 10)}
+	other.Upload(&domain.ShellIn{Cache: &domain.Cache{Key: "deps"}}, src)
+
+	out = manager.Download(in, nil)
+	defer os.RemoveAll(out)
+	assert.Equal(2, client.downloads)
+
+	content, err = ioutil.ReadFile(filepath.Join(out, "deps", "a.txt"))
+	assert.NoError(err)
+	assert.Equal("b", string(content))
+
+	// not use local store if checksum not provided by server
+	client.caches["deps"].Checksums = nil
+	out = manager.Download(in, nil)
+	defer os.RemoveAll(out)
+	assert.Equal(3, client.downloads)
+}
+
+func TestShouldRestoreCacheByRestoreKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_cache_restore_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	client := &cacheClient{caches: map[string]*domain.JobCache{}, files: map[string][]byte{}}
+	manager := &CacheManager{client: client}
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(os.MkdirAll(filepath.Join(src, "deps"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "deps", "a.txt"), []byte("a"), 0644))
+	manager.Upload(&domain.ShellIn{Cache: &domain.Cache{Key: "deps-v1"}}, src)
+
+	// not found
+	in := &domain.ShellIn{Cache: &domain.Cache{Key: "deps-v2", RestoreKeys: []string{"other-"}}}
+	assert.Empty(manager.Download(in, nil))
+	assert.Empty(in.Cache.HitKey)
+
+	// restored by prefix
+	in = &domain.ShellIn{
+		Inputs: domain.Variables{"OS": "linux"},
+		Cache:  &domain.Cache{Key: "deps-v2", RestoreKeys: []string{"other-", "deps-${OS}", "deps-"}},
+	}
+
+	out := manager.Download(in, nil)
+	defer os.RemoveAll(out)
+
+	assert.Equal("deps-v1", in.Cache.HitKey)
+	assert.Equal("deps-linux", in.Cache.RestoreKeys[1])
+	assert.True(util.IsFileExists(filepath.Join(out, "deps", "a.txt")))
+
+	// uploaded by primary key
+	manager.Upload(in, src)
+	assert.NotNil(client.caches["deps-v2"])
+}
+
+func TestShouldResolveCacheKeyWithHashFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &cacheClient{}
+	manager := &CacheManager{client: client}
+
+	var received []string
+	hashFiles := func(patterns []string) (string, error) {
+		received = patterns
+		return "abc", nil
+	}
+
+	in := &domain.ShellIn{
+		Inputs: domain.Variables{"OS": "linux"},
+		Cache: &domain.Cache{
+			Key:         "deps-${OS}-${ hashFiles('**/go.sum', \"go.mod\") }",
+			RestoreKeys: []string{"deps-${OS}-"},
+		},
+	}
+
+	manager.Resolve(in, hashFiles)
+	assert.Equal("deps-linux-abc", in.Cache.Key)
+	assert.Equal("deps-linux-", in.Cache.RestoreKeys[0])
+	assert.Equal([]string{"**/go.sum", "go.mod"}, received)
+
+	assert.False(in.Cache.Unresolved)
+
+	// unresolved if failed, and skip both restore and upload
+	client.caches = map[string]*domain.JobCache{"deps-": {Id: "cache", Key: "deps-", Files: []string{"file"}}}
+
+	in.Cache.Key = "deps-${hashFiles('go.sum')}"
+	in.Cache.RestoreKeys = []string{"deps-${hashFiles('go.sum')}", "deps-"}
+	failed := func(patterns []string) (string, error) {
+		return "", fmt.Errorf("failed")
+	}
+
+	assert.Empty(manager.Download(in, failed))
+	assert.True(in.Cache.Unresolved)
+	assert.Equal("deps-${hashFiles('go.sum')}", in.Cache.Key)
+	assert.Equal([]string{"", "deps-"}, in.Cache.RestoreKeys)
+	assert.Equal(0, client.downloads)
+
+	src, err := ioutil.TempDir("", "test_cache_unresolved_")
+	assert.NoError(err)
+	defer os.RemoveAll(src)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "a.txt"), []byte("a"), 0644))
+	manager.Upload(in, src)
+	assert.Equal(0, client.puts)
+}
+
+func TestShouldSkipUploadIfCacheUnchanged(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_cache_unchanged_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	client := &cacheClient{
+		formats: []string{util.ArchiveFormatTarGzip},
+		caches:  map[string]*domain.JobCache{},
+		files:   map[string][]byte{},
+	}
+	manager := &CacheManager{client: client}
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(os.MkdirAll(filepath.Join(src, "deps"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "deps", "a.txt"), []byte("a"), 0644))
+
+	in := &domain.ShellIn{Cache: &domain.Cache{Key: "deps"}}
+	manager.Upload(in, src)
+	assert.Equal(1, client.puts)
+
+	// restored but not changed
+	out := manager.Download(in, nil)
+	defer os.RemoveAll(out)
+	assert.NotEmpty(in.Cache.Manifest)
+
+	output := filepath.Join(dir, "output")
+	assert.NoError(util.CopyDir(out, output))
+
+	manager.Upload(in, output)
+	assert.Equal(1, client.puts)
+
+	// changed
+	assert.NoError(ioutil.WriteFile(filepath.Join(output, "deps", "b.txt"), []byte("b"), 0644))
+	manager.Upload(in, output)
+	assert.Equal(2, client.puts)
+
+	// restored from other key
+	in = &domain.ShellIn{Cache: &domain.Cache{Key: "deps-v2", RestoreKeys: []string{"deps"}}}
+	out = manager.Download(in, nil)
+	defer os.RemoveAll(out)
+	assert.Equal("deps", in.Cache.HitKey)
+
+	manager.Upload(in, out)
+	assert.Equal(3, client.puts)
+}
+
+func TestShouldNegotiateCacheFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(util.ArchiveFormatTarZstd, negotiateCacheFormat([]string{"zip", "tar.gz", "tar.zst"}))
+	assert.Equal(util.ArchiveFormatTarGzip, negotiateCacheFormat([]string{"tar.gz", "tar.xz"}))
+	assert.Equal(util.ArchiveFormatZip, negotiateCacheFormat(nil))
+}
+
+func TestShouldEvictLeastRecentlyUsedCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_cache_store_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	store := NewCacheStore(filepath.Join(dir, "store"), 1)
+
+	// 3 archives with 400KB for each
+	caches := map[string]*domain.JobCache{}
+	for i, id := range []string{"c1", "c2", "c3"} {
+		archive := filepath.Join(dir, id)
+		data := make([]byte, 400*1024)
+		data[0] = byte(i)
+		assert.NoError(ioutil.WriteFile(archive, data, 0644))
+		caches[id] = &domain.JobCache{Id: id, Checksums: map[string]string{"file": sha256Of(data)}}
+
+		blob, err := store.Add(archive)
+		assert.NoError(err)
+
+		mtime := time.Now().Add(time.Duration(i) * time.Minute)
+		assert.NoError(os.Chtimes(blob, mtime, mtime))
+	}
+
+	// c1 is least recently used
+	removed := store.Evict()
+	assert.Len(removed, 1)
+
+	_, ok := store.Lookup(caches["c1"], "file")
+	assert.False(ok)
+
+	_, ok = store.Lookup(caches["c2"], "file")
+	assert.True(ok)
+}
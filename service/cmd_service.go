@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -25,10 +27,12 @@ type (
 	CmdService struct {
 		pluginManager *PluginManager
 		cacheManager  *CacheManager
+		wsManager     *WorkspaceManager
 
 		cmdIn <-chan []byte
 
 		executor executor.Executor
+		plugin   *domain.PluginRef // plugin of running cmd
 		mux      sync.Mutex
 	}
 )
@@ -85,10 +89,33 @@ func (s *CmdService) start() {
 	}()
 }
 
+// ListPlugins get plugins loaded in plugin dir
+func (s *CmdService) ListPlugins() ([]*domain.PluginInfo, error) {
+	return s.pluginManager.List()
+}
+
+// RemovePlugins delete plugin by 'name' for all versions or 'name@version', the plugin of running cmd cannot be removed
+func (s *CmdService) RemovePlugins(name string) ([]*domain.PluginInfo, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	ref, err := domain.ParsePluginRef(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.IsRunning() && s.plugin != nil && (s.plugin.DirName() == name || ref.Match(s.plugin.Name, s.plugin.Ref)) {
+		return nil, ErrorPluginInUse
+	}
+
+	return s.pluginManager.Remove(name)
+}
+
 func (s *CmdService) release() {
 	if s.executor != nil {
 		s.executor.Close()
 		s.executor = nil
+		s.plugin = nil
 
 		cm := config.GetInstance()
 		cm.FireEvent(domain.EventOnIdle)
@@ -120,19 +147,46 @@ func (s *CmdService) execShell(in *domain.ShellIn) (out error) {
 
 	cm.FireEvent(domain.EventOnBusy)
 
+	var plugin *domain.PluginRef
 	if in.HasPlugin() {
-		err := s.pluginManager.Load(in.Plugin)
+		plugin, err = domain.ParsePluginRef(in.Plugin)
+		util.PanicIfErr(err)
+		plugin.Checksum = in.PluginChecksum
+		plugin.Archive = in.PluginArchive
+
+		err = s.pluginManager.Load(plugin)
 		util.PanicIfErr(err)
+
+		err = applyPluginManifest(in, plugin)
+		util.PanicIfErr(err)
+
+		if plugin.FromMirror {
+			sendLog(cm.Client, in, fmt.Sprintf("Plugin %s is unavailable from source, loaded from mirror", plugin))
+		}
+
+		sendLog(cm.Client, in, fmt.Sprintf("Plugin %s loaded at %s", plugin, plugin.Commit))
+
+		if plugin.Manifest != nil && plugin.Manifest.HasDocker() {
+			sendLog(cm.Client, in, fmt.Sprintf("Plugin %s runs in image %s", plugin, plugin.Manifest.Docker.Image))
+		}
 	}
 
-	// all cache will move to job dir after started
-	cacheSrcDir := ""
+	// cache is loaded by executor once job dir is ready, and moved to job dir after started
+	var cacheLoader executor.CacheLoader
 	if in.HasCache() {
-		cacheSrcDir = s.cacheManager.Download(in)
+		cacheLoader = func(hashFiles executor.HashFilesFunc) string {
+			return s.cacheManager.Download(in, hashFiles)
+		}
 	}
 
 	s.loadSecretForDocker(in)
+	s.loadSecretForCheckout(in)
+
+	// workspace in docker volume is cleaned up by docker executor
+	inVolume := in.HasDockerOption() && cm.IsFromDocker && !(cm.K8sEnabled && cm.K8sPodExecutor)
+	wsCleanup := s.wsManager.Prepare(in, !inVolume)
 
+	s.plugin = plugin
 	s.executor = executor.NewExecutor(executor.Options{
 		K8s: &domain.K8sConfig{
 			Enabled:   cm.K8sEnabled,
@@ -140,18 +194,28 @@ func (s *CmdService) execShell(in *domain.ShellIn) (out error) {
 			Namespace: cm.K8sNamespace,
 			PodName:   cm.K8sPodName,
 			PodIp:     cm.K8sPodIp,
+			NodeName:  cm.K8sNodeName,
+
+			PodExecutor:    cm.K8sPodExecutor,
+			WorkspaceClaim: cm.K8sWorkspaceClaim,
 		},
 		AgentId:                   cm.Token,
 		Parent:                    cm.AppCtx,
 		Workspace:                 cm.Workspace,
 		WorkspaceFromDockerVolume: cm.IsFromDocker,
 		PluginDir:                 cm.PluginDir,
-		CacheSrcDir:               cacheSrcDir,
+		CacheLoader:               cacheLoader,
 		Cmd:                       in,
 		Vars:                      s.initEnv(),
 		SecretVars:                s.initSecretEnv(in),
 		ConfigVars:                s.initConfigEnv(in),
 		Volumes:                   cm.Volumes,
+		DefaultResources:          cm.StepResources(),
+		CgroupParent:              cm.CgroupParent,
+		DockerHost:                cm.Docker(),
+		WorkspaceCleanup:          wsCleanup,
+		JobDirMode:                cm.JobDirMode,
+		Plugin:                    plugin,
 	})
 
 	err = s.executor.Init()
@@ -430,10 +494,62 @@ func (s *CmdService) loadSecretForDocker(in *domain.ShellIn) {
 	}
 }
 
+func (s *CmdService) loadSecretForCheckout(in *domain.ShellIn) {
+	if !in.HasCheckout() || !in.Checkout.HasSecret() {
+		return
+	}
+
+	cm := config.GetInstance()
+	secret, err := cm.Client.GetSecret(in.Checkout.Secret)
+	util.PanicIfErr(err)
+
+	switch s := secret.(type) {
+	case *domain.AuthSecret:
+		in.Checkout.AuthContent = s.Pair
+	case *domain.RSASecret:
+		in.Checkout.KeyContent = s.Pair
+	default:
+		panic(fmt.Errorf("the secret '%s' is invalid, the secret category should be 'Auth pair' or 'SSH RSA'", in.Checkout.Secret))
+	}
+}
+
 // ---------------------------------
 // 	Utils
 // ---------------------------------
 
+// applyPluginManifest check os of the plugin and apply inputs with defaults from manifest
+func applyPluginManifest(in *domain.ShellIn, plugin *domain.PluginRef) error {
+	if plugin.Manifest == nil {
+		return nil
+	}
+
+	// the plugin image will be the runtime container even if it's a host shell step
+	if plugin.Manifest.HasDocker() {
+		in.Dockers = plugin.Manifest.ToRuntimeOption(in.Dockers)
+
+		if err := validateDockerOptions(in); err != nil {
+			return fmt.Errorf("plugin '%s': %s", plugin, err.Error())
+		}
+	}
+
+	// the step runs in linux container if docker option defined
+	targetOS := runtime.GOOS
+	if in.HasDockerOption() {
+		targetOS = util.OSLinux
+	}
+
+	if !plugin.Manifest.IsSupportedOS(targetOS) {
+		return fmt.Errorf("plugin '%s' supports %s only, cannot run on %s",
+			plugin, strings.Join(plugin.Manifest.OS, ", "), targetOS)
+	}
+
+	if err := plugin.Manifest.ApplyInputs(in.Inputs); err != nil {
+		return fmt.Errorf("plugin '%s': %s", plugin, err.Error())
+	}
+
+	return nil
+}
+
 func initShellCmd(in *domain.ShellIn) error {
 	// init cmd id if undefined
 	if util.IsEmptyString(in.ID) {
@@ -445,5 +561,15 @@ func initShellCmd(in *domain.ShellIn) error {
 		in.Inputs = make(domain.Variables, 10)
 	}
 
+	return validateDockerOptions(in)
+}
+
+// validateDockerOptions check docker options of cmd, the invalid option is returned as error instead of panic on start
+func validateDockerOptions(in *domain.ShellIn) error {
+	for _, option := range in.Dockers {
+		if err := option.Validate(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
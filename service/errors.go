@@ -10,4 +10,7 @@ var (
 	ErrorCmdMissingSessionID      = errors.New("agent: the session id is required for cmd")
 	ErrorCmdSessionNotFound       = errors.New("agent: session not found")
 	ErrorCmdSessionMissingScripts = errors.New("agent: script is missing")
+
+	ErrorPluginNotFound = errors.New("agent: plugin not found")
+	ErrorPluginInUse    = errors.New("agent: plugin is used by running cmd")
 )
@@ -2,6 +2,8 @@ package service
 
 import (
 	"github.com/flowci/flow-agent-x/config"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 )
@@ -18,8 +20,9 @@ func GetCmdService() *CmdService {
 
 	once.Do(func() {
 		singleton = &CmdService{
-			pluginManager: NewPluginManager(appConfig.PluginDir, appConfig.Server),
+			pluginManager: NewPluginManager(appConfig.PluginDir, appConfig.Server, appConfig.PluginMirror, appConfig.PluginGcDays),
 			cacheManager:  NewCacheManager(),
+			wsManager:     NewWorkspaceManager(),
 			cmdIn:         cmdIn,
 		}
 		singleton.start()
@@ -32,12 +35,38 @@ func NewCacheManager() *CacheManager {
 	appConfig := config.GetInstance()
 	return &CacheManager{
 		client: appConfig.Client,
+		store:  NewCacheStore(appConfig.CacheDir, appConfig.CacheMaxSize),
 	}
 }
 
-func NewPluginManager(dir, server string) *PluginManager {
+// NewCacheStore create local cache store in dir with max size in MB, nil if max size is 0
+func NewCacheStore(dir string, maxSize int64) *CacheStore {
+	if maxSize <= 0 {
+		return nil
+	}
+
+	_ = os.MkdirAll(filepath.Join(dir, cacheStoreBlobDir), os.ModePerm)
+
+	return &CacheStore{
+		dir:     dir,
+		maxSize: maxSize * 1024 * 1024,
+	}
+}
+
+func NewWorkspaceManager() *WorkspaceManager {
+	appConfig := config.GetInstance()
+	return &WorkspaceManager{
+		client: appConfig.Client,
+		root:   appConfig.Workspace,
+		policy: appConfig.WorkspacePolicy(),
+	}
+}
+
+func NewPluginManager(dir, server, mirror string, gcDays int) *PluginManager {
 	return &PluginManager{
 		dir:    dir,
 		server: strings.TrimRight(server, "/"),
+		mirror: mirror,
+		gcDays: gcDays,
 	}
 }
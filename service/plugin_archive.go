@@ -0,0 +1,134 @@
+package service
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+)
+
+const (
+	pluginArchiveMarkerSuffix = ".sha256"
+	pluginArchiveCommitPrefix = "sha256:"
+	pluginDownloadTimeout     = 10 * time.Minute
+)
+
+type (
+	// pluginUnavailableError the archive server cannot be reached, the plugin can be loaded from mirror
+	pluginUnavailableError struct {
+		err error
+	}
+)
+
+var (
+	pluginArchiveExts = []string{".tar.gz", ".tgz", ".zip"}
+
+	pluginHttpClient = &http.Client{Timeout: pluginDownloadTimeout}
+)
+
+// loadArchive download archive from http url or read it from local file, verify the sha256 if expected is given,
+// then extract it to plugin dir, the single top level dir in archive will be stripped.
+// The plugin.Commit will be set to 'sha256:<sha256 of archive>', and the extraction is skipped if it's unchanged
+func (p *PluginManager) loadArchive(dir, src, expected string, plugin *domain.PluginRef) (out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = fmt.Errorf("plugin '%s': %s", plugin, e.Error())
+		if _, ok := e.(*pluginUnavailableError); ok {
+			out = &pluginUnavailableError{err: out}
+		}
+	})
+
+	p.cleanupTempDirs(plugin)
+
+	file := src
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		tmp, err := ioutil.TempFile(p.dir, "."+plugin.DirName()+pluginTempSuffix)
+		util.PanicIfErr(err)
+
+		file = tmp.Name()
+		defer os.Remove(file)
+
+		err = downloadPluginArchive(src, tmp)
+		_ = tmp.Close()
+		util.PanicIfErr(err)
+	}
+
+	checksum, err := util.FileSha256(file)
+	util.PanicIfErr(err)
+
+	if util.HasString(expected) && !strings.EqualFold(checksum, expected) {
+		panic(fmt.Errorf("archive sha256 %s not matched with %s", checksum, expected))
+	}
+
+	plugin.Commit = pluginArchiveCommitPrefix + checksum
+
+	marker := p.archiveMarker(plugin)
+	if raw, err := ioutil.ReadFile(marker); err == nil && string(raw) == checksum && util.IsFileExists(dir) {
+		return
+	}
+
+	tmpDir, err := ioutil.TempDir(p.dir, "."+plugin.DirName()+pluginTempSuffix)
+	util.PanicIfErr(err)
+	defer os.RemoveAll(tmpDir)
+
+	util.PanicIfErr(util.ExtractArchive(file, tmpDir))
+
+	root := archiveRoot(tmpDir)
+	if util.HasString(plugin.Checksum) {
+		files, err := pluginChecksum(root)
+		util.PanicIfErr(err)
+
+		if !strings.EqualFold(files, plugin.Checksum) {
+			panic(fmt.Errorf("checksum %s not matched with %s", files, plugin.Checksum))
+		}
+	}
+
+	// remove marker first, the dir will be extracted again if it's failed to replace
+	_ = os.Remove(marker)
+	util.PanicIfErr(replaceDir(dir, root))
+	util.PanicIfErr(ioutil.WriteFile(marker, []byte(checksum), 0644))
+	return
+}
+
+// archiveMarker file contains sha256 of the archive that extracted to plugin dir
+func (p *PluginManager) archiveMarker(plugin *domain.PluginRef) string {
+	return filepath.Join(p.dir, "."+plugin.DirName()+pluginArchiveMarkerSuffix)
+}
+
+func downloadPluginArchive(url string, w io.Writer) error {
+	resp, err := pluginHttpClient.Get(url)
+	if err != nil {
+		return &pluginUnavailableError{err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return &pluginUnavailableError{err: fmt.Errorf("unable to download archive from %s, status %d", url, resp.StatusCode)}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to download archive from %s, status %d", url, resp.StatusCode)
+	}
+
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// archiveRoot get the single top level dir of extracted archive, or dir itself if there are multiple files
+func archiveRoot(dir string) string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil || len(files) != 1 || !files[0].IsDir() {
+		return dir
+	}
+	return filepath.Join(dir, files[0].Name())
+}
+
+func (e *pluginUnavailableError) Error() string {
+	return e.err.Error()
+}
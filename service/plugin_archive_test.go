@@ -0,0 +1,193 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+	git "gopkg.in/src-d/go-git.v4"
+)
+
+func TestShouldLoadPluginFromArchiveUrl(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	archive := tarGzipPlugin(t, "hello-1.0/", "v1")
+	downloads := 0
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		downloads++
+		_, _ = w.Write(archive)
+	}))
+	defer server.Close()
+
+	manager := NewPluginManager(dir, server.URL, "", 0)
+
+	plugin, _ := domain.ParsePluginRef("hello@1.0")
+	plugin.Archive = &domain.PluginArchive{Url: server.URL + "/hello-1.0.tar.gz", Sha256: sha256Of(archive)}
+
+	assert.NoError(manager.Load(plugin))
+	assert.Equal("sha256:"+sha256Of(archive), plugin.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello@1.0"), "v1")
+
+	// load again, the extracted dir is reused
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "hello@1.0", "local"), []byte("x"), 0644))
+	assert.NoError(manager.Load(plugin))
+	assert.True(util.IsFileExists(filepath.Join(dir, "hello@1.0", "local")))
+	assert.Equal(2, downloads)
+
+	// sha256 not matched
+	plugin.Archive.Sha256 = sha256Of([]byte("other"))
+	assert.Error(manager.Load(plugin))
+	assertPluginContent(t, filepath.Join(dir, "hello@1.0"), "v1")
+
+	// sha256 is required
+	plugin.Archive.Sha256 = ""
+	assert.Error(manager.Load(plugin))
+}
+
+func TestShouldLoadPluginFromMirrorIfServerUnavailable(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	mirror, err := ioutil.TempDir("", "test_plugin_mirror_")
+	assert.NoError(err)
+	defer os.RemoveAll(mirror)
+
+	// archive in mirror with sha256 file
+	archive := tarGzipPlugin(t, "", "v1")
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello@v1.tar.gz"), archive, 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello@v1.tar.gz.sha256"), []byte(sha256Of(archive)+"  hello@v1.tar.gz\n"), 0644))
+
+	// archive in mirror without sha256 file
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello@v2.tar.gz"), tarGzipPlugin(t, "", "v2"), 0644))
+
+	// git repo in mirror
+	repo, err := git.PlainInit(filepath.Join(mirror, "world"), false)
+	assert.NoError(err)
+	v2 := commitPluginFile(t, repo, filepath.Join(mirror, "world"), "v2")
+
+	manager := NewPluginManager(dir, "http://127.0.0.1:1", mirror, 0)
+
+	plugin, _ := domain.ParsePluginRef("hello@v1")
+	assert.NoError(manager.Load(plugin))
+	assert.True(plugin.FromMirror)
+	assert.Equal("sha256:"+sha256Of(archive), plugin.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello@v1"), "v1")
+
+	plugin, _ = domain.ParsePluginRef("world")
+	assert.NoError(manager.Load(plugin))
+	assert.True(plugin.FromMirror)
+	assert.Equal(v2.String(), plugin.Commit)
+	assertPluginContent(t, filepath.Join(dir, "world"), "v2")
+
+	plugin, _ = domain.ParsePluginRef("notfound")
+	assert.Error(manager.Load(plugin))
+
+	// sha256 is required for archive in mirror
+	plugin, _ = domain.ParsePluginRef("hello@v2")
+	assert.Error(manager.Load(plugin))
+	assert.False(util.IsFileExists(filepath.Join(dir, "hello@v2")))
+
+	// sha256 file not matched
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello@v2.tar.gz.sha256"), []byte(sha256Of(archive)), 0644))
+	assert.Error(manager.Load(plugin))
+}
+
+func TestShouldNotLoadFromMirrorIfArchiveNotMatched(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	mirror, err := ioutil.TempDir("", "test_plugin_mirror_")
+	assert.NoError(err)
+	defer os.RemoveAll(mirror)
+
+	archive := tarGzipPlugin(t, "", "v1")
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello-1.0.tar.gz"), archive, 0644))
+
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte("tampered"))
+	}))
+	defer server.Close()
+
+	manager := NewPluginManager(dir, server.URL, mirror, 0)
+
+	plugin, _ := domain.ParsePluginRef("hello@1.0")
+	plugin.Archive = &domain.PluginArchive{Url: server.URL + "/hello-1.0.tar.gz", Sha256: sha256Of(archive)}
+
+	// checksum mismatch of source should not fallback to mirror
+	assert.Error(manager.Load(plugin))
+	assert.False(plugin.FromMirror)
+
+	// server error
+	status = http.StatusBadGateway
+	assert.NoError(manager.Load(plugin))
+	assert.True(plugin.FromMirror)
+	assertPluginContent(t, filepath.Join(dir, "hello@1.0"), "v1")
+}
+
+func TestShouldRejectArchiveWithIllegalPath(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugin_archive_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "plugin.tar.gz")
+	assert.NoError(ioutil.WriteFile(file, tarGzipPlugin(t, "../", "v1"), 0644))
+	assert.Error(util.ExtractArchive(file, filepath.Join(dir, "out")))
+
+	assert.NoError(ioutil.WriteFile(file, []byte("not an archive"), 0644))
+	assert.Error(util.ExtractArchive(file, filepath.Join(dir, "out")))
+}
+
+// tarGzipPlugin create .tar.gz content with plugin.sh under the prefix
+func tarGzipPlugin(t *testing.T, prefix, content string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	if prefix != "" {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: prefix, Typeflag: tar.TypeDir, Mode: 0755}))
+	}
+
+	assert.NoError(t, tw.WriteHeader(&tar.Header{
+		Name:     prefix + "plugin.sh",
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(content)),
+	}))
+	_, err := tw.Write([]byte(content))
+	assert.NoError(t, err)
+
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func sha256Of(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	git "gopkg.in/src-d/go-git.v4"
+)
+
+const (
+	pluginGcInterval = time.Hour
+)
+
+// List get plugins in plugin dir sorted by last used time desc
+func (p *PluginManager) List() ([]*domain.PluginInfo, error) {
+	files, err := ioutil.ReadDir(p.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []*domain.PluginInfo
+	for _, f := range files {
+		if !f.IsDir() || strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		list = append(list, p.info(f))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LastUsed.After(list[j].LastUsed)
+	})
+
+	return list, nil
+}
+
+// Remove delete all versions of plugin by name, or the single version by 'name@version',
+// the plugin dir locked by loading is skipped
+func (p *PluginManager) Remove(name string) ([]*domain.PluginInfo, error) {
+	ref, err := domain.ParsePluginRef(name)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := p.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []*domain.PluginInfo
+	for _, plugin := range list {
+		if plugin.Dir != name && !ref.Match(plugin.Name, plugin.Version) {
+			continue
+		}
+
+		if err = p.removeDir(plugin.Dir); err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, plugin)
+	}
+
+	if len(removed) == 0 {
+		return nil, ErrorPluginNotFound
+	}
+
+	return removed, nil
+}
+
+// gc remove plugins unused for gcDays, it's applied at most once per hour
+func (p *PluginManager) gc(current string) {
+	if p.gcDays <= 0 || !p.isGcDue() {
+		return
+	}
+
+	list, err := p.List()
+	if err != nil {
+		util.LogWarn("agent: unable to list plugins for gc: %s", err.Error())
+		return
+	}
+
+	expired := time.Now().Add(-time.Duration(p.gcDays) * 24 * time.Hour)
+	for _, plugin := range list {
+		if plugin.Dir == current || plugin.LastUsed.After(expired) {
+			continue
+		}
+
+		if err = p.removeDir(plugin.Dir); err != nil {
+			util.LogWarn("agent: unable to gc plugin '%s': %s", plugin.Dir, err.Error())
+			continue
+		}
+
+		util.LogInfo("agent: plugin '%s' removed, unused since %s", plugin.Dir, plugin.LastUsed.Format(time.RFC3339))
+	}
+}
+
+// isGcDue check and update the last gc time, so that gc is applied only once per interval by concurrent loads
+func (p *PluginManager) isGcDue() bool {
+	p.gcMux.Lock()
+	defer p.gcMux.Unlock()
+
+	if time.Since(p.lastGc) < pluginGcInterval {
+		return false
+	}
+
+	p.lastGc = time.Now()
+	return true
+}
+
+// touch mark the plugin dir as recently used
+func (p *PluginManager) touch(dir string) {
+	now := time.Now()
+	util.LogIfError(os.Chtimes(dir, now, now))
+}
+
+// removeDir delete the plugin dir and its archive marker if it's not locked by loading,
+// the lock file is kept since other processes may wait on it
+func (p *PluginManager) removeDir(dirName string) error {
+	lock, err := util.LockFile(filepath.Join(p.dir, "."+dirName+pluginLockSuffix), 0)
+	if err != nil {
+		return fmt.Errorf("plugin '%s' is loading: %s", dirName, err.Error())
+	}
+	defer lock.Unlock()
+
+	if err = os.RemoveAll(filepath.Join(p.dir, dirName)); err != nil {
+		return err
+	}
+
+	_ = os.Remove(filepath.Join(p.dir, "."+dirName+pluginArchiveMarkerSuffix))
+	return nil
+}
+
+func (p *PluginManager) info(f os.FileInfo) *domain.PluginInfo {
+	name, version := domain.ParsePluginDirName(f.Name())
+	dir := filepath.Join(p.dir, f.Name())
+
+	info := &domain.PluginInfo{
+		Name:     name,
+		Version:  version,
+		Dir:      f.Name(),
+		LastUsed: f.ModTime(),
+	}
+
+	if raw, err := ioutil.ReadFile(filepath.Join(p.dir, "."+f.Name()+pluginArchiveMarkerSuffix)); err == nil {
+		info.Commit = pluginArchiveCommitPrefix + string(raw)
+	} else if repo, err := git.PlainOpen(dir); err == nil {
+		if head, err := repo.Head(); err == nil {
+			info.Commit = head.Hash().String()
+		}
+	}
+
+	_ = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			info.Size += fi.Size()
+		}
+		return nil
+	})
+
+	return info
+}
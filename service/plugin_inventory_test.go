@@ -0,0 +1,118 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldListAndRemovePlugins(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for i, name := range []string{"hello", "hello@v1", "world", ".hello.lock"} {
+		assert.NoError(os.MkdirAll(filepath.Join(dir, name), os.ModePerm))
+		assert.NoError(ioutil.WriteFile(filepath.Join(dir, name, "plugin.sh"), []byte("echo"), 0644))
+
+		mtime := time.Now().Add(-time.Duration(i) * time.Hour)
+		assert.NoError(os.Chtimes(filepath.Join(dir, name), mtime, mtime))
+	}
+
+	manager := NewPluginManager(dir, "", "", 0)
+
+	list, err := manager.List()
+	assert.NoError(err)
+	assert.Len(list, 3)
+	assert.Equal("hello", list[0].Dir)
+	assert.Equal("v1", list[1].Version)
+	assert.Equal(int64(4), list[2].Size)
+
+	removed, err := manager.Remove("hello@v1")
+	assert.NoError(err)
+	assert.Len(removed, 1)
+	assert.True(util.IsFileExists(filepath.Join(dir, "hello")))
+
+	_, err = manager.Remove("hello@v1")
+	assert.Equal(ErrorPluginNotFound, err)
+
+	// cannot be removed while loading
+	lock, err := util.LockFile(filepath.Join(dir, ".world"+pluginLockSuffix), 0)
+	assert.NoError(err)
+	_, err = manager.Remove("world")
+	assert.Error(err)
+	lock.Unlock()
+
+	removed, err = manager.Remove("world")
+	assert.NoError(err)
+	assert.Len(removed, 1)
+}
+
+func TestShouldRemovePluginByVersionWithSlash(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"hello", "hello@feature%2Fx"} {
+		assert.NoError(os.MkdirAll(filepath.Join(dir, name), os.ModePerm))
+	}
+
+	manager := NewPluginManager(dir, "", "", 0)
+
+	removed, err := manager.Remove("hello@feature/x")
+	assert.NoError(err)
+	assert.Len(removed, 1)
+	assert.Equal("hello@feature%2Fx", removed[0].Dir)
+	assert.True(util.IsFileExists(filepath.Join(dir, "hello")))
+
+	_, err = manager.Remove("hello@")
+	assert.Error(err)
+}
+
+func TestShouldRemoveUnusedPluginsAfterLoaded(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	mirror, err := ioutil.TempDir("", "test_plugin_mirror_")
+	assert.NoError(err)
+	defer os.RemoveAll(mirror)
+
+	archive := tarGzipPlugin(t, "", "v1")
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello.tar.gz"), archive, 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(mirror, "hello.tar.gz.sha256"), []byte(sha256Of(archive)), 0644))
+
+	for i, name := range []string{"hello", "recent", "unused"} {
+		assert.NoError(os.MkdirAll(filepath.Join(dir, name), os.ModePerm))
+
+		mtime := time.Now().Add(-time.Duration(i*3) * 24 * time.Hour)
+		if name == "hello" {
+			mtime = time.Now().Add(-30 * 24 * time.Hour)
+		}
+		assert.NoError(os.Chtimes(filepath.Join(dir, name), mtime, mtime))
+	}
+
+	manager := NewPluginManager(dir, "http://127.0.0.1:1", mirror, 5)
+	assert.NoError(manager.Load(&domain.PluginRef{Name: "hello"}))
+
+	assert.True(util.IsFileExists(filepath.Join(dir, "hello")))
+	assert.True(util.IsFileExists(filepath.Join(dir, "recent")))
+	assert.False(util.IsFileExists(filepath.Join(dir, "unused")))
+
+	list, err := manager.List()
+	assert.NoError(err)
+	assert.Equal("hello", list[0].Dir)
+	assert.True(time.Since(list[0].LastUsed) < time.Minute)
+}
@@ -1,10 +1,33 @@
 package service
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/flowci/flow-agent-x/domain"
 	"github.com/flowci/flow-agent-x/util"
 	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+	githttp "gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pluginLockTimeout = 5 * time.Minute
+	pluginLockSuffix  = ".lock"
+	pluginTempSuffix  = ".tmp-"
+	pluginOldSuffix   = "old"
 )
 
 type PluginManager struct {
@@ -13,36 +36,303 @@ type PluginManager struct {
 
 	// server url
 	server string
+
+	// local dir of plugin archives or git repos, optional
+	mirror string
+
+	// remove plugins unused for N days, 0 for disabled
+	gcDays int
+	gcMux  sync.Mutex
+	lastGc time.Time
 }
 
-func (p *PluginManager) Load(name string) error {
-	url := p.server + "/git/plugins/" + name
-	dir := filepath.Join(p.dir, name)
+// Load clone or pull the plugin to plugin dir, the pinned version will be checked out to a dedicated dir,
+// the resolved commit id is set to plugin.Commit, and plugin.yml is parsed to plugin.Manifest if it's existed.
+// The plugin is locked during loading, the new clone is renamed to plugin dir after verified,
+// and the existing plugin will be recloned if it cannot be updated or verified.
+// The plugin will be loaded from archive if plugin.Archive is present, and from mirror if source is unreachable.
+// The plugin dir is marked as recently used, and the plugins unused for gcDays are removed after loaded
+func (p *PluginManager) Load(plugin *domain.PluginRef) error {
+	if plugin.Archive != nil {
+		if err := plugin.Archive.Validate(); err != nil {
+			return err
+		}
+	}
+
+	dir := filepath.Join(p.dir, plugin.DirName())
 
-	util.LogInfo("agent: clone plugin '%s' to '%s'", url, dir)
+	lock, err := util.LockFile(filepath.Join(p.dir, "."+plugin.DirName()+pluginLockSuffix), pluginLockTimeout)
+	if err != nil {
+		return fmt.Errorf("plugin '%s': %s", plugin, err.Error())
+	}
+	defer lock.Unlock()
+
+	if err = p.loadFromSource(dir, plugin); err != nil {
+		if util.IsEmptyString(p.mirror) || !isPluginSourceUnavailable(err) {
+			return err
+		}
 
-	err := p.clone(dir, url)
+		util.LogWarn("agent: plugin '%s' cannot be loaded from source, try mirror '%s': %s", plugin, p.mirror, err.Error())
 
-	if err == git.ErrRepositoryAlreadyExists {
-		return p.pull(dir, url)
+		if err = p.loadFromMirror(dir, plugin); err != nil {
+			return err
+		}
 	}
 
-	return err
+	plugin.Manifest, err = p.readManifest(dir)
+	if err != nil {
+		return fmt.Errorf("plugin '%s': %s", plugin, err.Error())
+	}
+
+	p.touch(dir)
+	p.gc(plugin.DirName())
+	return nil
+}
+
+func (p *PluginManager) loadFromSource(dir string, plugin *domain.PluginRef) error {
+	if plugin.Archive != nil {
+		util.LogInfo("agent: load plugin '%s' from '%s' to '%s'", plugin, plugin.Archive.Url, dir)
+		return p.loadArchive(dir, plugin.Archive.Url, plugin.Archive.Sha256, plugin)
+	}
+
+	url := p.server + "/git/plugins/" + plugin.Name
+	util.LogInfo("agent: load plugin '%s' from '%s' to '%s'", plugin, url, dir)
+	return p.loadGit(dir, url, plugin)
+}
+
+// loadFromMirror load plugin from archive '<mirror>/<file name of archive url>' or '<mirror>/<dir name>.tar.gz|.tgz|.zip',
+// or git repo '<mirror>/<name>.git' or '<mirror>/<name>'. The archive is verified by sha256 of plugin.Archive,
+// or '<archive>.sha256' next to it, which is required if plugin.Checksum is not given
+func (p *PluginManager) loadFromMirror(dir string, plugin *domain.PluginRef) error {
+	var archives []string
+
+	if plugin.Archive != nil {
+		archives = append(archives, path.Base(plugin.Archive.Url))
+	}
+
+	for _, ext := range pluginArchiveExts {
+		archives = append(archives, plugin.DirName()+ext)
+	}
+
+	for _, name := range archives {
+		file := filepath.Join(p.mirror, name)
+		if !util.IsFileExists(file) {
+			continue
+		}
+
+		expected, err := mirrorArchiveSha256(file, plugin)
+		if err != nil {
+			return err
+		}
+
+		util.LogInfo("agent: load plugin '%s' from mirror '%s' to '%s'", plugin, file, dir)
+		if err = p.loadArchive(dir, file, expected, plugin); err != nil {
+			return err
+		}
+
+		plugin.FromMirror = true
+		return nil
+	}
+
+	for _, name := range []string{plugin.Name + ".git", plugin.Name} {
+		repo := filepath.Join(p.mirror, name)
+		if !util.IsFileExists(repo) {
+			continue
+		}
+
+		util.LogInfo("agent: load plugin '%s' from mirror '%s' to '%s'", plugin, repo, dir)
+		if err := p.loadGit(dir, repo, plugin); err != nil {
+			return err
+		}
+
+		plugin.FromMirror = true
+		return nil
+	}
+
+	return fmt.Errorf("plugin '%s': not found in mirror '%s'", plugin, p.mirror)
+}
+
+// loadGit sync plugin from git repo, and remove the archive marker since the dir is replaced by git repo
+func (p *PluginManager) loadGit(dir, url string, plugin *domain.PluginRef) error {
+	if err := p.sync(dir, url, plugin); err != nil {
+		return err
+	}
+
+	_ = os.Remove(p.archiveMarker(plugin))
+	return nil
+}
+
+// sync update the existing plugin, or reclone it if not existed or cannot be updated
+func (p *PluginManager) sync(dir, url string, plugin *domain.PluginRef) error {
+	p.cleanupTempDirs(plugin)
+
+	if util.IsFileExists(dir) {
+		err := p.update(dir, url, plugin)
+		if err == nil {
+			return nil
+		}
+
+		util.LogWarn("agent: plugin '%s' cannot be updated or verified, reclone: %s", plugin, err.Error())
+	}
+
+	return p.reclone(dir, url, plugin)
+}
+
+// update fetch into the existing plugin, and checkout the resolved commit in a temp copy of it, which replaces
+// the plugin dir after verified. The plugin dir is not copied if it's already at the commit and verified
+func (p *PluginManager) update(dir, url string, plugin *domain.PluginRef) error {
+	hash, err := p.fetch(dir, url, plugin)
+	if err != nil {
+		return err
+	}
+
+	plugin.Commit = hash.String()
+	if p.isUnchanged(dir, plugin.Commit) && p.verify(dir, plugin) == nil {
+		return nil
+	}
+
+	tmp, err := ioutil.TempDir(p.dir, "."+plugin.DirName()+pluginTempSuffix)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	work := filepath.Join(tmp, plugin.DirName())
+	if err = util.CopyDir(dir, work); err != nil {
+		return err
+	}
+
+	if err = p.checkout(work, hash, plugin); err != nil {
+		return err
+	}
+
+	if err = p.verify(work, plugin); err != nil {
+		return err
+	}
+
+	return replaceDir(dir, work)
+}
+
+// reclone clone the plugin into temp dir, and replace the plugin dir after verified
+func (p *PluginManager) reclone(dir, url string, plugin *domain.PluginRef) (out error) {
+	tmp, err := ioutil.TempDir(p.dir, "."+plugin.DirName()+pluginTempSuffix)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if out != nil {
+			_ = os.RemoveAll(tmp)
+		}
+	}()
+
+	err = p.clone(tmp, url, plugin)
+	if err != nil {
+		return err
+	}
+
+	if plugin.IsPinned() {
+		hash, err := p.fetch(tmp, url, plugin)
+		if err != nil {
+			return err
+		}
+
+		if err = p.checkout(tmp, hash, plugin); err != nil {
+			return err
+		}
+	}
+
+	if err = p.verify(tmp, plugin); err != nil {
+		return err
+	}
+
+	return replaceDir(dir, tmp)
+}
+
+// isUnchanged the plugin dir is at the commit and the worktree is clean
+func (p *PluginManager) isUnchanged(dir, commit string) bool {
+	repo, err := git.PlainOpen(dir)
+	if err != nil {
+		return false
+	}
+
+	head, err := repo.Head()
+	if err != nil || head.Hash().String() != commit {
+		return false
+	}
+
+	workTree, err := repo.Worktree()
+	if err != nil {
+		return false
+	}
+
+	status, err := workTree.Status()
+	return err == nil && status.IsClean()
 }
 
-func (p *PluginManager) clone(dir, url string) error {
+func (p *PluginManager) clone(dir, url string, plugin *domain.PluginRef) error {
 	options := &git.CloneOptions{
 		URL:      url,
 		Progress: os.Stdout,
 	}
 
+	// checkout later by version
+	if plugin.IsPinned() {
+		options.NoCheckout = true
+		options.Tags = git.AllTags
+	}
+
 	_, err := git.PlainClone(dir, false, options)
 	return err
 }
 
-func (p *PluginManager) pull(dir, url string) (out error) {
+// fetch branches and tags into the plugin repo if needed, the worktree is not touched.
+// return the commit of pinned version, or the commit of remote branch of HEAD if it's not pinned
+func (p *PluginManager) fetch(dir, url string, plugin *domain.PluginRef) (hash plumbing.Hash, out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = fmt.Errorf("plugin '%s': %s", plugin, e.Error())
+	})
+
+	repo, err := git.PlainOpen(dir)
+	util.PanicIfErr(err)
+
+	// the commit is immutable, skip fetching if it's existed
+	if plugin.IsCommit() {
+		if hash, err = p.resolve(repo, plugin); err == nil {
+			return
+		}
+	}
+
+	p.updateRemote(repo, url)
+
+	err = repo.Fetch(&git.FetchOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Tags:       git.AllTags,
+		Force:      true,
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		panic(err)
+	}
+
+	if plugin.IsPinned() {
+		hash, err = p.resolve(repo, plugin)
+		util.PanicIfErr(err)
+		return
+	}
+
+	head, err := repo.Head()
+	util.PanicIfErr(err)
+
+	remote, err := repo.ResolveRevision(plumbing.Revision("refs/remotes/origin/" + head.Name().Short()))
+	util.PanicIfErr(err)
+	return *remote, nil
+}
+
+// checkout the worktree to the commit, the HEAD is detached for pinned version, otherwise the branch is reset to it
+func (p *PluginManager) checkout(dir string, hash plumbing.Hash, plugin *domain.PluginRef) (out error) {
 	defer util.RecoverPanic(func(e error) {
-		out = e
+		out = fmt.Errorf("plugin '%s': %s", plugin, e.Error())
 	})
 
 	repo, err := git.PlainOpen(dir)
@@ -51,24 +341,249 @@ func (p *PluginManager) pull(dir, url string) (out error) {
 	workTree, err := repo.Worktree()
 	util.PanicIfErr(err)
 
-	// update remote url if url been changed
-	remote, err := repo.Remote("origin")
+	if plugin.IsPinned() {
+		err = workTree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true})
+	} else {
+		err = workTree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+	}
+	util.PanicIfErr(err)
+
+	plugin.Commit = hash.String()
+	return
+}
+
+// verify the worktree is clean and checked out to expected commit, and checksum matched if it's given.
+// the plugin.Commit will be set to the commit of HEAD
+func (p *PluginManager) verify(dir string, plugin *domain.PluginRef) (out error) {
+	defer util.RecoverPanic(func(e error) {
+		out = fmt.Errorf("plugin '%s': verify failed: %s", plugin, e.Error())
+	})
+
+	repo, err := git.PlainOpen(dir)
+	util.PanicIfErr(err)
+
+	head, err := repo.Head()
 	util.PanicIfErr(err)
 
-	config := remote.Config()
-	if config.URLs[0] != url {
-		err = repo.DeleteRemote("origin")
+	// expected commit of pinned version is resolved in checkout, otherwise it's the remote branch
+	expected := plugin.Commit
+	if !plugin.IsPinned() {
+		remote, err := repo.ResolveRevision(plumbing.Revision("refs/remotes/origin/" + head.Name().Short()))
 		util.PanicIfErr(err)
+		expected = remote.String()
+	}
+
+	if head.Hash().String() != expected {
+		panic(fmt.Errorf("HEAD %s is not at expected commit %s", head.Hash(), expected))
+	}
+
+	workTree, err := repo.Worktree()
+	util.PanicIfErr(err)
 
-		config.URLs[0] = url
-		_, err = repo.CreateRemote(config)
+	status, err := workTree.Status()
+	util.PanicIfErr(err)
+
+	if !status.IsClean() {
+		panic(fmt.Errorf("worktree is not clean"))
+	}
+
+	if util.HasString(plugin.Checksum) {
+		checksum, err := pluginChecksum(dir)
 		util.PanicIfErr(err)
+
+		if !strings.EqualFold(checksum, plugin.Checksum) {
+			panic(fmt.Errorf("checksum %s not matched with %s", checksum, plugin.Checksum))
+		}
+	}
+
+	plugin.Commit = head.Hash().String()
+	return
+}
+
+// resolve commit of plugin ref, which can be tag, branch, full or short commit id
+func (p *PluginManager) resolve(repo *git.Repository, plugin *domain.PluginRef) (plumbing.Hash, error) {
+	revisions := []string{
+		"refs/tags/" + plugin.Ref,
+		"refs/remotes/origin/" + plugin.Ref,
+	}
+
+	if plugin.IsCommit() {
+		revisions = append(revisions, plugin.Ref)
+	}
+
+	for _, rev := range revisions {
+		if hash, err := repo.ResolveRevision(plumbing.Revision(rev)); err == nil {
+			return *hash, nil
+		}
+	}
+
+	if plugin.IsCommit() {
+		if hash, ok := p.findCommitByPrefix(repo, plugin.Ref); ok {
+			return hash, nil
+		}
+	}
+
+	return plumbing.ZeroHash, fmt.Errorf("version '%s' not found", plugin.Ref)
+}
+
+// findCommitByPrefix find commit by short id, not found if it's ambiguous
+func (p *PluginManager) findCommitByPrefix(repo *git.Repository, prefix string) (plumbing.Hash, bool) {
+	iter, err := repo.CommitObjects()
+	if err != nil {
+		return plumbing.ZeroHash, false
 	}
+	defer iter.Close()
 
-	err = workTree.Pull(&git.PullOptions{RemoteName: "origin"})
-	if err == git.NoErrAlreadyUpToDate {
+	var found []plumbing.Hash
+	_ = iter.ForEach(func(c *object.Commit) error {
+		if strings.HasPrefix(c.Hash.String(), prefix) {
+			found = append(found, c.Hash)
+		}
 		return nil
+	})
+
+	if len(found) != 1 {
+		return plumbing.ZeroHash, false
 	}
 
-	return err
+	return found[0], true
+}
+
+// updateRemote update remote url if url been changed
+func (p *PluginManager) updateRemote(repo *git.Repository, url string) {
+	remote, err := repo.Remote("origin")
+	util.PanicIfErr(err)
+
+	remoteConfig := remote.Config()
+	if remoteConfig.URLs[0] == url {
+		return
+	}
+
+	err = repo.DeleteRemote("origin")
+	util.PanicIfErr(err)
+
+	remoteConfig.URLs[0] = url
+	_, err = repo.CreateRemote(remoteConfig)
+	util.PanicIfErr(err)
+}
+
+// readManifest parse plugin.yml in plugin dir, nil if not existed
+func (p *PluginManager) readManifest(dir string) (*domain.PluginManifest, error) {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, domain.PluginManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return domain.ParsePluginManifest(raw)
+}
+
+// mirrorArchiveSha256 get expected sha256 of archive in mirror from plugin.Archive or '<archive>.sha256',
+// the content of sha256 file can be the output of sha256sum
+func mirrorArchiveSha256(file string, plugin *domain.PluginRef) (string, error) {
+	if plugin.Archive != nil {
+		return plugin.Archive.Sha256, nil
+	}
+
+	raw, err := ioutil.ReadFile(file + pluginArchiveMarkerSuffix)
+	if err == nil {
+		if fields := strings.Fields(string(raw)); len(fields) > 0 {
+			return fields[0], nil
+		}
+	}
+
+	if util.HasString(plugin.Checksum) {
+		return "", nil
+	}
+
+	return "", fmt.Errorf("plugin '%s': '%s' is required to verify archive in mirror", plugin, file+pluginArchiveMarkerSuffix)
+}
+
+// isPluginSourceUnavailable the plugin source cannot be reached, ex: connection refused or server error
+func isPluginSourceUnavailable(err error) bool {
+	switch e := err.(type) {
+	case *pluginUnavailableError:
+		return true
+	case net.Error:
+		return true
+	case *plumbing.UnexpectedError:
+		if httpErr, ok := e.Err.(*githttp.Err); ok {
+			return httpErr.StatusCode() >= http.StatusInternalServerError
+		}
+	}
+	return false
+}
+
+// replaceDir replace dir by src, the existing dir is renamed aside before src is renamed to it and removed after,
+// so the dir is never partially removed or written, and the existing dir will be restored if it's failed
+func replaceDir(dir, src string) error {
+	old := filepath.Join(filepath.Dir(dir), "."+filepath.Base(dir)+pluginTempSuffix+pluginOldSuffix)
+	if err := os.RemoveAll(old); err != nil {
+		return err
+	}
+
+	if util.IsFileExists(dir) {
+		if err := os.Rename(dir, old); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(src, dir); err != nil {
+		_ = os.Rename(old, dir)
+		return err
+	}
+
+	return os.RemoveAll(old)
+}
+
+// cleanupTempDirs remove temp dirs of plugin that left by crashed loading
+func (p *PluginManager) cleanupTempDirs(plugin *domain.PluginRef) {
+	dirs, _ := filepath.Glob(filepath.Join(p.dir, "."+plugin.DirName()+pluginTempSuffix+"*"))
+	for _, dir := range dirs {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// pluginChecksum sha256 of plugin files exclude .git, each file is hashed as
+// '{slash separated relative path}\n{size}\n{content}' in lexical order
+func pluginChecksum(dir string) (string, error) {
+	hash := sha256.New()
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(hash, "%s\n%d\n", filepath.ToSlash(rel), info.Size())
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(hash, f)
+		return err
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
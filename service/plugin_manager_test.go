@@ -0,0 +1,247 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+	git "gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+func TestShouldLoadPinnedPluginToVersionDir(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := ioutil.TempDir("", "test_plugin_server_")
+	assert.NoError(err)
+	defer os.RemoveAll(server)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(server, "git", "plugins", "hello")
+	repo, err := git.PlainInit(src, false)
+	assert.NoError(err)
+
+	v1 := commitPluginFile(t, repo, src, "v1")
+	_, err = repo.CreateTag("v1", v1, nil)
+	assert.NoError(err)
+	v2 := commitPluginFile(t, repo, src, "v2")
+
+	manager := NewPluginManager(dir, server, "", 0)
+
+	// load latest
+	latest := &domain.PluginRef{Name: "hello"}
+	assert.NoError(manager.Load(latest))
+	assert.Equal(v2.String(), latest.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello"), "v2")
+
+	// load by tag
+	tag, _ := domain.ParsePluginRef("hello@v1")
+	assert.NoError(manager.Load(tag))
+	assert.Equal(v1.String(), tag.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello@v1"), "v1")
+
+	// load by short commit
+	commit, _ := domain.ParsePluginRef("hello@" + v2.String()[:8])
+	assert.NoError(manager.Load(commit))
+	assert.Equal(v2.String(), commit.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello@"+v2.String()[:8]), "v2")
+
+	// load by branch after new commit
+	v3 := commitPluginFile(t, repo, src, "v3")
+	branch, _ := domain.ParsePluginRef("hello@master")
+	assert.NoError(manager.Load(branch))
+	assert.Equal(v3.String(), branch.Commit)
+
+	notFound, _ := domain.ParsePluginRef("hello@v9")
+	assert.Error(manager.Load(notFound))
+	assert.False(util.IsFileExists(filepath.Join(dir, "hello@v9")))
+}
+
+func commitPluginFile(t *testing.T, repo *git.Repository, dir, content string) plumbing.Hash {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "plugin.sh"), []byte(content), 0644))
+
+	worktree, err := repo.Worktree()
+	assert.NoError(t, err)
+
+	_, err = worktree.Add("plugin.sh")
+	assert.NoError(t, err)
+
+	hash, err := worktree.Commit(content, &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@flow.ci", When: time.Now()},
+	})
+	assert.NoError(t, err)
+	return hash
+}
+
+func assertPluginContent(t *testing.T, dir, expected string) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, "plugin.sh"))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(content))
+}
+
+func TestShouldRecloneCorruptedPluginAndVerifyChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := ioutil.TempDir("", "test_plugin_server_")
+	assert.NoError(err)
+	defer os.RemoveAll(server)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(server, "git", "plugins", "hello")
+	repo, err := git.PlainInit(src, false)
+	assert.NoError(err)
+	v1 := commitPluginFile(t, repo, src, "v1")
+
+	manager := NewPluginManager(dir, server, "", 0)
+
+	// half written plugin dir and temp dir left by crash
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "hello", ".git"), os.ModePerm))
+	assert.NoError(os.MkdirAll(filepath.Join(dir, ".hello.tmp-123"), os.ModePerm))
+
+	plugin := &domain.PluginRef{Name: "hello"}
+	assert.NoError(manager.Load(plugin))
+	assert.Equal(v1.String(), plugin.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello"), "v1")
+	assert.False(util.IsFileExists(filepath.Join(dir, ".hello.tmp-123")))
+
+	// modified plugin file should be recloned
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "hello", "plugin.sh"), []byte("changed"), 0644))
+	assert.NoError(manager.Load(plugin))
+	assertPluginContent(t, filepath.Join(dir, "hello"), "v1")
+
+	// verify checksum
+	checksum, err := pluginChecksum(filepath.Join(dir, "hello"))
+	assert.NoError(err)
+
+	plugin = &domain.PluginRef{Name: "hello", Checksum: checksum}
+	assert.NoError(manager.Load(plugin))
+
+	plugin = &domain.PluginRef{Name: "hello", Checksum: "invalid"}
+	assert.Error(manager.Load(plugin))
+}
+
+func TestShouldReplacePluginDirByRename(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := ioutil.TempDir("", "test_plugin_server_")
+	assert.NoError(err)
+	defer os.RemoveAll(server)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(server, "git", "plugins", "hello")
+	repo, err := git.PlainInit(src, false)
+	assert.NoError(err)
+	commitPluginFile(t, repo, src, "v1")
+
+	manager := NewPluginManager(dir, server, "", 0)
+	assert.NoError(manager.Load(&domain.PluginRef{Name: "hello"}))
+
+	before, err := os.Stat(filepath.Join(dir, "hello"))
+	assert.NoError(err)
+
+	// should not touch plugin dir if unchanged
+	assert.NoError(manager.Load(&domain.PluginRef{Name: "hello"}))
+
+	after, err := os.Stat(filepath.Join(dir, "hello"))
+	assert.NoError(err)
+	assert.True(os.SameFile(before, after))
+
+	// should replace by new dir instead of pulling in place
+	v2 := commitPluginFile(t, repo, src, "v2")
+
+	plugin := &domain.PluginRef{Name: "hello"}
+	assert.NoError(manager.Load(plugin))
+	assert.Equal(v2.String(), plugin.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello"), "v2")
+
+	after, err = os.Stat(filepath.Join(dir, "hello"))
+	assert.NoError(err)
+	assert.False(os.SameFile(before, after))
+
+	files, err := filepath.Glob(filepath.Join(dir, ".hello.tmp-*"))
+	assert.NoError(err)
+	assert.Empty(files)
+
+	// should keep tracking the branch after updated
+	v3 := commitPluginFile(t, repo, src, "v3")
+
+	assert.NoError(manager.Load(plugin))
+	assert.Equal(v3.String(), plugin.Commit)
+	assertPluginContent(t, filepath.Join(dir, "hello"), "v3")
+
+	// should restore the dir if failed to replace
+	assert.Error(replaceDir(filepath.Join(dir, "hello"), filepath.Join(dir, "not-existed")))
+	assertPluginContent(t, filepath.Join(dir, "hello"), "v3")
+}
+
+func TestShouldReadPluginManifestAndApplyInputs(t *testing.T) {
+	assert := assert.New(t)
+
+	server, err := ioutil.TempDir("", "test_plugin_server_")
+	assert.NoError(err)
+	defer os.RemoveAll(server)
+
+	dir, err := ioutil.TempDir("", "test_plugins_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(server, "git", "plugins", "hello")
+	repo, err := git.PlainInit(src, false)
+	assert.NoError(err)
+
+	manifest := "name: hello\nos: [windows]\ninputs:\n  - name: GREETING\n    value: hi\n  - name: TARGET\n    required: true\n"
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, domain.PluginManifestFile), []byte(manifest), 0644))
+	commitPluginFile(t, repo, src, "v1")
+
+	worktree, _ := repo.Worktree()
+	_, _ = worktree.Add(domain.PluginManifestFile)
+	_, err = worktree.Commit("manifest", &git.CommitOptions{
+		Author: &object.Signature{Name: "test", Email: "test@flow.ci", When: time.Now()},
+	})
+	assert.NoError(err)
+
+	plugin := &domain.PluginRef{Name: "hello"}
+	assert.NoError(NewPluginManager(dir, server, "", 0).Load(plugin))
+	assert.NotNil(plugin.Manifest)
+	assert.Equal("hello", plugin.Manifest.Name)
+
+	// only for windows
+	in := &domain.ShellIn{Inputs: domain.Variables{"TARGET": "world"}}
+	in.Dockers = []*domain.DockerOption{{Image: "ubuntu"}}
+	assert.Error(applyPluginManifest(in, plugin))
+
+	plugin.Manifest.OS = nil
+	assert.NoError(applyPluginManifest(in, plugin))
+	assert.Equal("hi", in.Inputs["GREETING"])
+
+	delete(in.Inputs, "TARGET")
+	assert.Error(applyPluginManifest(in, plugin))
+}
+
+func TestShouldReturnErrorOnInvalidDockerOption(t *testing.T) {
+	assert := assert.New(t)
+
+	in := &domain.ShellIn{Dockers: []*domain.DockerOption{{Image: "ubuntu", Memory: "abc"}}}
+
+	err := initShellCmd(in)
+	assert.Error(err)
+	assert.Contains(err.Error(), "'memory'")
+
+	in.Dockers[0].Memory = "1g"
+	assert.NoError(initShellCmd(in))
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flowci/flow-agent-x/api"
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+var (
+	// diskFreeInMB get free disk space of the path, replaceable in test
+	diskFreeInMB = func(path string) (int64, error) {
+		usage, err := disk.Usage(path)
+		if err != nil {
+			return 0, err
+		}
+		return int64(usage.Free / 1024 / 1024), nil
+	}
+)
+
+type WorkspaceManager struct {
+	client api.Client
+	root   string
+	policy *domain.WorkspacePolicy
+
+	lastFlowId string
+	lastJobId  string
+}
+
+// Prepare get cleanup actions of the cmd and apply them on the host workspace, return the actions that should be
+// applied by executor as well if not onHost, ex: in docker volume, which checkout is kept in job dir on the host
+func (wm *WorkspaceManager) Prepare(in *domain.ShellIn, onHost bool) *domain.WorkspaceCleanup {
+	cleanup := &domain.WorkspaceCleanup{Policy: wm.policy}
+	flowId := util.ParseString(in.FlowId)
+
+	if in.JobId != wm.lastJobId {
+		if wm.policy.CleanAfter && util.HasString(wm.lastFlowId) {
+			cleanup.CleanFlows = append(cleanup.CleanFlows, wm.lastFlowId)
+		}
+
+		if wm.policy.CleanBefore && !(wm.policy.CleanAfter && flowId == wm.lastFlowId) {
+			cleanup.CleanFlows = append(cleanup.CleanFlows, flowId)
+		}
+
+		wm.lastFlowId = flowId
+		wm.lastJobId = in.JobId
+	}
+
+	wm.apply(in, flowId, cleanup)
+	if !onHost {
+		return cleanup
+	}
+	return nil
+}
+
+func (wm *WorkspaceManager) apply(in *domain.ShellIn, current string, cleanup *domain.WorkspaceCleanup) {
+	defer util.RecoverPanic(func(e error) {
+		util.LogWarn("Unable to cleanup workspace: %s", e.Error())
+	})
+
+	for _, flowId := range cleanup.CleanFlows {
+		dir := filepath.Join(wm.root, flowId)
+		if !util.IsFileExists(dir) {
+			continue
+		}
+
+		util.PanicIfErr(os.RemoveAll(dir))
+		wm.log(in, fmt.Sprintf("Workspace: flow dir %s cleaned", flowId))
+	}
+
+	// mark current flow dir as recently used
+	currentDir := filepath.Join(wm.root, current)
+	util.PanicIfErr(os.MkdirAll(currentDir, os.ModePerm))
+
+	now := time.Now()
+	util.PanicIfErr(os.Chtimes(currentDir, now, now))
+
+	if !wm.policy.HasEviction() {
+		return
+	}
+
+	flows := wm.listFlowDirs(current)
+
+	// evict flows over the limit, the current flow is counted
+	if wm.policy.KeepFlows > 0 {
+		for len(flows) > wm.policy.KeepFlows-1 {
+			wm.evict(in, flows[len(flows)-1], fmt.Sprintf("keep %d flows", wm.policy.KeepFlows))
+			flows = flows[:len(flows)-1]
+		}
+	}
+
+	if wm.policy.MinFreeDisk > 0 {
+		for len(flows) > 0 {
+			free, err := diskFreeInMB(wm.root)
+			util.PanicIfErr(err)
+
+			if free >= wm.policy.MinFreeDisk {
+				break
+			}
+
+			wm.evict(in, flows[len(flows)-1], fmt.Sprintf("free disk %dMB < %dMB", free, wm.policy.MinFreeDisk))
+			flows = flows[:len(flows)-1]
+		}
+	}
+}
+
+// listFlowDirs list flow dirs exclude current one, sorted by last used time desc
+func (wm *WorkspaceManager) listFlowDirs(current string) []os.FileInfo {
+	files, err := ioutil.ReadDir(wm.root)
+	util.PanicIfErr(err)
+
+	var flows []os.FileInfo
+	for _, f := range files {
+		if !f.IsDir() || f.Name() == current || isReservedWorkspaceDir(f.Name()) {
+			continue
+		}
+		flows = append(flows, f)
+	}
+
+	sort.Slice(flows, func(i, j int) bool {
+		return flows[i].ModTime().After(flows[j].ModTime())
+	})
+
+	return flows
+}
+
+func (wm *WorkspaceManager) evict(in *domain.ShellIn, flow os.FileInfo, reason string) {
+	err := os.RemoveAll(filepath.Join(wm.root, flow.Name()))
+	util.PanicIfErr(err)
+
+	wm.log(in, fmt.Sprintf("Workspace: flow dir %s evicted (last used %s), %s",
+		flow.Name(), flow.ModTime().Format(time.RFC3339), reason))
+}
+
+func (wm *WorkspaceManager) log(in *domain.ShellIn, msg string) {
+	util.LogInfo(msg)
+	if wm.client != nil {
+		sendLog(wm.client, in, msg)
+	}
+}
+
+// isReservedWorkspaceDir the hidden dirs (plugins, logs) and bin are not flow dirs
+func isReservedWorkspaceDir(name string) bool {
+	return strings.HasPrefix(name, ".") || name == "bin"
+}
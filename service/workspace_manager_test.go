@@ -0,0 +1,99 @@
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/flowci/flow-agent-x/domain"
+	"github.com/flowci/flow-agent-x/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestWorkspace(assert *assert.Assertions, flows ...string) string {
+	ws, err := ioutil.TempDir("", "test_ws_")
+	assert.NoError(err)
+
+	for i, flow := range flows {
+		dir := filepath.Join(ws, flow)
+		assert.NoError(os.MkdirAll(dir, os.ModePerm))
+
+		mtime := time.Now().Add(time.Duration(-len(flows)+i) * time.Minute)
+		assert.NoError(os.Chtimes(dir, mtime, mtime))
+	}
+
+	return ws
+}
+
+func TestShouldCleanFlowDirOnJobChanged(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newTestWorkspace(assert, "flow-a", "flow-b")
+	defer os.RemoveAll(ws)
+
+	wm := &WorkspaceManager{
+		root:   ws,
+		policy: &domain.WorkspacePolicy{CleanBefore: true, CleanAfter: true},
+	}
+
+	// first job of flow-a, clean before
+	assert.Nil(wm.Prepare(&domain.ShellIn{FlowId: "flow-a", JobId: "1"}, true))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-a")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-b")))
+
+	// the next step of the same job, nothing cleaned
+	assert.NoError(ioutil.WriteFile(filepath.Join(ws, "flow-a", "file"), []byte("a"), 0644))
+	assert.Nil(wm.Prepare(&domain.ShellIn{FlowId: "flow-a", JobId: "1"}, true))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-a", "file")))
+
+	// job of flow-b arrived, flow-a cleaned after and flow-b cleaned before
+	// the checkout on host is cleaned as well for workspace in docker volume
+	assert.NoError(ioutil.WriteFile(filepath.Join(ws, "flow-b", "file"), []byte("b"), 0644))
+	cleanup := wm.Prepare(&domain.ShellIn{FlowId: "flow-b", JobId: "2"}, false)
+	assert.Equal([]string{"flow-a", "flow-b"}, cleanup.CleanFlows)
+	assert.False(util.IsFileExists(filepath.Join(ws, "flow-a")))
+	assert.False(util.IsFileExists(filepath.Join(ws, "flow-b", "file")))
+}
+
+func TestShouldEvictLeastRecentlyUsedFlowDirs(t *testing.T) {
+	assert := assert.New(t)
+
+	ws := newTestWorkspace(assert, "flow-a", "flow-b", "flow-c", "bin", ".plugins")
+	defer os.RemoveAll(ws)
+
+	free := int64(100)
+	diskFree := diskFreeInMB
+	defer func() { diskFreeInMB = diskFree }()
+
+	diskFreeInMB = func(path string) (int64, error) {
+		return free, nil
+	}
+
+	wm := &WorkspaceManager{
+		root:   ws,
+		policy: &domain.WorkspacePolicy{KeepFlows: 3},
+	}
+
+	// keep 3 flows include the current flow-d
+	wm.Prepare(&domain.ShellIn{FlowId: "flow-d", JobId: "1"}, true)
+	assert.False(util.IsFileExists(filepath.Join(ws, "flow-a")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-b")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-c")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-d")))
+
+	// evict until free disk is enough
+	wm.policy = &domain.WorkspacePolicy{MinFreeDisk: 200}
+	diskFreeInMB = func(path string) (int64, error) {
+		free += 60
+		return free, nil
+	}
+
+	wm.Prepare(&domain.ShellIn{FlowId: "flow-d", JobId: "2"}, true)
+	assert.False(util.IsFileExists(filepath.Join(ws, "flow-b")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-c")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "flow-d")))
+	assert.True(util.IsFileExists(filepath.Join(ws, "bin")))
+	assert.True(util.IsFileExists(filepath.Join(ws, ".plugins")))
+}
@@ -0,0 +1,301 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	ArchiveFormatZip     = "zip"     // legacy, without permissions and symlinks
+	ArchiveFormatTarGzip = "tar.gz"  // tar with gzip
+	ArchiveFormatTarZstd = "tar.zst" // tar with zstd
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte{'P', 'K', 0x03, 0x04}
+)
+
+// IsTarFormat the archive format is tar with compression
+func IsTarFormat(format string) bool {
+	return format == ArchiveFormatTarGzip || format == ArchiveFormatTarZstd
+}
+
+// DetectArchiveFormat detect format from the magic bytes of archive file
+func DetectArchiveFormat(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	head := make([]byte, 4)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+
+	switch {
+	case bytes.HasPrefix(head, zipMagic):
+		return ArchiveFormatZip, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		return ArchiveFormatTarGzip, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		return ArchiveFormatTarZstd, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format")
+	}
+}
+
+// TarArchive stream file or dir of src into dest with gzip or zstd compression, the entries are named
+// with base name of src as root, and the permissions, symlinks and mtimes are preserved
+func TarArchive(src, dest, format string) (out error) {
+	defer RecoverPanic(func(e error) {
+		out = e
+	})
+
+	f, err := os.Create(dest)
+	PanicIfErr(err)
+	defer f.Close()
+
+	var compressor io.WriteCloser
+	switch format {
+	case ArchiveFormatTarGzip:
+		compressor = gzip.NewWriter(f)
+	case ArchiveFormatTarZstd:
+		compressor, err = zstd.NewWriter(f)
+		PanicIfErr(err)
+	default:
+		panic(fmt.Errorf("unsupported tar format '%s'", format))
+	}
+
+	tw := tar.NewWriter(compressor)
+	src = filepath.Clean(src)
+	base := filepath.Dir(src)
+
+	err = filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+
+		header.Name = filepath.ToSlash(rel)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		_, err = io.Copy(tw, file)
+		return err
+	})
+	PanicIfErr(err)
+
+	PanicIfErr(tw.Close())
+	PanicIfErr(compressor.Close())
+	return
+}
+
+// ExtractArchive extract .zip, .tar.gz or .tar.zst file to dest dir, the format is detected by content
+func ExtractArchive(archive, dest string) error {
+	format, err := DetectArchiveFormat(archive)
+	if err != nil {
+		return err
+	}
+
+	if format == ArchiveFormatZip {
+		return Unzip(archive, dest)
+	}
+
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if format == ArchiveFormatTarGzip {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return Untar(gz, dest)
+	}
+
+	decoder, err := zstd.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+	return Untar(decoder, dest)
+}
+
+// Untar extract tar stream to dest dir with permissions, symlinks and mtimes, error if the entry is outside of dest
+// or written through symlink, and the symlink pointing to outside of dest is skipped
+func Untar(r io.Reader, dest string) error {
+	reader := tar.NewReader(r)
+
+	// mode and mtime of dir are applied after all entries extracted, since the entries cannot be added
+	// into read-only dir, and the mtime is changed by adding entries
+	dirModes := make(map[string]os.FileMode)
+	dirTimes := make(map[string]time.Time)
+
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		target, err := SafeJoin(dest, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if hasSymlinkParent(dest, target) {
+			return fmt.Errorf("illegal file path '%s' in archive, the parent is a symlink", header.Name)
+		}
+
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err = os.MkdirAll(target, os.ModePerm); err != nil {
+				return err
+			}
+
+			dirModes[target] = mode.Perm()
+			dirTimes[target] = header.ModTime
+
+		case tar.TypeReg, tar.TypeRegA:
+			if err = untarFile(target, reader, mode); err != nil {
+				return err
+			}
+
+			_ = os.Chtimes(target, header.ModTime, header.ModTime)
+
+		case tar.TypeSymlink:
+			if !isLinkInside(dest, target, header.Linkname) {
+				LogWarn("symlink %s -> %s is outside of %s, skipped", header.Name, header.Linkname, dest)
+				continue
+			}
+
+			if err = os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+				return err
+			}
+
+			_ = os.Remove(target)
+			if err = os.Symlink(header.Linkname, target); err != nil {
+				LogWarn("unable to create symlink %s: %s", header.Name, err.Error())
+			}
+
+		case tar.TypeLink:
+			source, err := SafeJoin(dest, header.Linkname)
+			if err != nil {
+				return err
+			}
+
+			if hasSymlinkParent(dest, source) {
+				return fmt.Errorf("illegal link '%s' in archive, the parent is a symlink", header.Linkname)
+			}
+
+			_ = os.Remove(target)
+			if err = os.Link(source, target); err != nil {
+				return err
+			}
+		}
+
+		// devices, fifos and others are skipped
+	}
+
+	for dir, mode := range dirModes {
+		_ = os.Chmod(dir, mode)
+	}
+
+	for dir, mtime := range dirTimes {
+		_ = os.Chtimes(dir, mtime, mtime)
+	}
+
+	return nil
+}
+
+func untarFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return err
+	}
+
+	_ = os.Remove(target)
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode.Perm())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = io.Copy(f, r); err != nil {
+		return err
+	}
+
+	// the mode is masked by umask on create
+	return f.Chmod(mode.Perm())
+}
+
+// isLinkInside the relative link target is resolved under dest dir
+func isLinkInside(dest, target, link string) bool {
+	if filepath.IsAbs(link) || strings.HasPrefix(link, "/") {
+		return false
+	}
+
+	dest = filepath.Clean(dest)
+	resolved := filepath.Join(filepath.Dir(target), link)
+	return resolved == dest || strings.HasPrefix(resolved, dest+string(os.PathSeparator))
+}
+
+// hasSymlinkParent any parent dir of target under dest is a symlink, the entry cannot be written through it
+func hasSymlinkParent(dest, target string) bool {
+	dest = filepath.Clean(dest)
+	for dir := filepath.Dir(target); len(dir) > len(dest); dir = filepath.Dir(dir) {
+		if info, err := os.Lstat(dir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
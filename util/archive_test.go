@@ -0,0 +1,138 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldTarAndExtractWithModesAndSymlinks(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_archive_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "node_modules")
+	assert.NoError(os.MkdirAll(filepath.Join(src, ".bin"), os.ModePerm))
+	assert.NoError(os.MkdirAll(filepath.Join(src, "tool"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "tool", "cli.js"), []byte("echo"), 0755))
+	assert.NoError(os.Symlink("../tool/cli.js", filepath.Join(src, ".bin", "tool")))
+
+	mtime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	assert.NoError(os.Chtimes(filepath.Join(src, "tool", "cli.js"), mtime, mtime))
+
+	for _, format := range []string{ArchiveFormatTarGzip, ArchiveFormatTarZstd} {
+		archive := filepath.Join(dir, "cache."+format)
+		assert.NoError(TarArchive(src, archive, format))
+
+		detected, err := DetectArchiveFormat(archive)
+		assert.NoError(err)
+		assert.Equal(format, detected)
+
+		dest := filepath.Join(dir, "out_"+format)
+		assert.NoError(ExtractArchive(archive, dest))
+
+		info, err := os.Stat(filepath.Join(dest, "node_modules", "tool", "cli.js"))
+		assert.NoError(err)
+		assert.Equal(os.FileMode(0755), info.Mode().Perm())
+		assert.True(info.ModTime().Equal(mtime))
+
+		link, err := os.Readlink(filepath.Join(dest, "node_modules", ".bin", "tool"))
+		assert.NoError(err)
+		assert.Equal("../tool/cli.js", link)
+	}
+}
+
+func TestShouldNotExtractOutsideOfDest(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_archive_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// symlink to outside is skipped
+	dest := filepath.Join(dir, "out")
+	assert.NoError(os.MkdirAll(dest, os.ModePerm))
+	assert.NoError(Untar(tarOf(t, &tar.Header{Name: "up", Typeflag: tar.TypeSymlink, Linkname: "../.."}), dest))
+	assert.False(IsFileExists(filepath.Join(dest, "up")))
+
+	// write through symlink is rejected
+	assert.NoError(os.Symlink(dir, filepath.Join(dest, "parent")))
+	err = Untar(tarOf(t, &tar.Header{Name: "parent/evil", Typeflag: tar.TypeReg, Mode: 0644}), dest)
+	assert.Error(err)
+	assert.False(IsFileExists(filepath.Join(dir, "evil")))
+
+	assert.Error(Untar(tarOf(t, &tar.Header{Name: "../evil", Typeflag: tar.TypeReg, Mode: 0644}), dest))
+}
+
+func TestShouldExtractIntoReadOnlyDir(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_archive_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	// ex: go module cache with 0555 dirs
+	archive := tarOf(t,
+		&tar.Header{Name: "mod/", Typeflag: tar.TypeDir, Mode: 0555},
+		&tar.Header{Name: "mod/pkg/", Typeflag: tar.TypeDir, Mode: 0555},
+		&tar.Header{Name: "mod/pkg/go.mod", Typeflag: tar.TypeReg, Mode: 0444},
+	)
+
+	dest := filepath.Join(dir, "out")
+	assert.NoError(Untar(archive, dest))
+
+	for _, path := range []string{"mod", filepath.Join("mod", "pkg")} {
+		info, err := os.Stat(filepath.Join(dest, path))
+		assert.NoError(err)
+		assert.Equal(os.FileMode(0555), info.Mode().Perm())
+	}
+
+	info, err := os.Stat(filepath.Join(dest, "mod", "pkg", "go.mod"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0444), info.Mode().Perm())
+
+	// make it writable to be removed
+	assert.NoError(os.Chmod(filepath.Join(dest, "mod", "pkg"), 0755))
+	assert.NoError(os.Chmod(filepath.Join(dest, "mod"), 0755))
+}
+
+func TestShouldCopyDirWithSymlinks(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_copy_dir_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	assert.NoError(os.MkdirAll(src, os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(src, "run.sh"), []byte("echo"), 0755))
+	assert.NoError(os.Symlink("run.sh", filepath.Join(src, "link")))
+
+	assert.NoError(CopyDir(src, filepath.Join(dir, "dst")))
+
+	link, err := os.Readlink(filepath.Join(dir, "dst", "link"))
+	assert.NoError(err)
+	assert.Equal("run.sh", link)
+
+	info, err := os.Stat(filepath.Join(dir, "dst", "run.sh"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0755), info.Mode().Perm())
+}
+
+func tarOf(t *testing.T, headers ...*tar.Header) *bytes.Buffer {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, h := range headers {
+		assert.NoError(t, tw.WriteHeader(h))
+	}
+	assert.NoError(t, tw.Close())
+	return &buf
+}
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 func IsFileExists(path string) bool {
@@ -98,15 +99,26 @@ func CopyDir(src string, dst string) (err error) {
 			continue
 		}
 
-		// skip symlinks.
+		// recreate symlinks with the same target
 		if entry.Mode()&os.ModeSymlink != 0 {
+			var link string
+			link, err = os.Readlink(srcPath)
+			PanicIfErr(err)
+
+			err = os.Symlink(link, dstPath)
+			PanicIfErr(err)
 			continue
 		}
 
 		err = CopyFile(srcPath, dstPath)
 		PanicIfErr(err)
+
+		err = os.Chtimes(dstPath, entry.ModTime(), entry.ModTime())
+		PanicIfErr(err)
 	}
 
+	err = os.Chtimes(dst, si.ModTime(), si.ModTime())
+	PanicIfErr(err)
 	return
 }
 
@@ -138,7 +150,8 @@ func Unzip(src string, dest string) (out error) {
 	defer r.Close()
 
 	for _, f := range r.File {
-		fpath := filepath.Join(dest, f.Name)
+		fpath, err := SafeJoin(dest, f.Name)
+		PanicIfErr(err)
 
 		if f.FileInfo().IsDir() {
 			_ = os.MkdirAll(fpath, os.ModePerm)
@@ -164,6 +177,18 @@ func Unzip(src string, dest string) (out error) {
 	return
 }
 
+// SafeJoin join the file name from archive to dest dir, error if the file is outside of dest dir
+func SafeJoin(dest, name string) (string, error) {
+	dest = filepath.Clean(dest)
+	target := filepath.Join(dest, name)
+
+	if target != dest && !strings.HasPrefix(target, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("illegal file path '%s' in archive", name)
+	}
+
+	return target, nil
+}
+
 func addFiles(w *zip.Writer, basePath, baseInZip, separator string) {
 	files, err := ioutil.ReadDir(basePath)
 	PanicIfErr(err)
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	fileLockRetryInterval = 100 * time.Millisecond
+)
+
+// FileLock exclusive lock on file that shared across processes
+type FileLock struct {
+	file *os.File
+}
+
+// LockFile create the file if not existed and wait for the exclusive lock until timeout
+func LockFile(path string, timeout time.Duration) (*FileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		ok, err := tryLockFile(file)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+
+		if ok {
+			return &FileLock{file: file}, nil
+		}
+
+		if time.Now().After(deadline) {
+			_ = file.Close()
+			return nil, fmt.Errorf("unable to lock file %s in %s", path, timeout)
+		}
+
+		time.Sleep(fileLockRetryInterval)
+	}
+}
+
+// Unlock release the lock, the lock file is kept for other processes
+func (l *FileLock) Unlock() {
+	_ = unlockFile(l.file)
+	_ = l.file.Close()
+}
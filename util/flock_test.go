@@ -0,0 +1,33 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldLockFileExclusively(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_lock_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "plugin.lock")
+
+	lock, err := LockFile(path, time.Second)
+	assert.NoError(err)
+
+	_, err = LockFile(path, 300*time.Millisecond)
+	assert.Error(err)
+
+	lock.Unlock()
+
+	lock, err = LockFile(path, time.Second)
+	assert.NoError(err)
+	lock.Unlock()
+}
@@ -0,0 +1,125 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// MatchPath match slash separated path with glob pattern, the '**' matches zero or more dirs,
+// '*' and '?' are the same as path.Match
+func MatchPath(pattern, name string) bool {
+	pattern = strings.Trim(filepath.ToSlash(pattern), "/")
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// MatchAnyPath match path with any of patterns
+func MatchAnyPath(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchPath(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// HashFiles sha256 of regular files under root that matched patterns, empty string if nothing matched
+func HashFiles(root string, patterns []string) (string, error) {
+	var files []string
+
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		if MatchAnyPath(patterns, rel) {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(files)
+
+	sums := make([]string, len(files))
+	for i, f := range files {
+		if sums[i], err = FileSha256(filepath.Join(root, filepath.FromSlash(f))); err != nil {
+			return "", err
+		}
+	}
+
+	return HashOfSums(sums), nil
+}
+
+// HashOfSums sha256 over the sha256 of files which are sorted by path, empty string if no files
+func HashOfSums(sums []string) string {
+	if len(sums) == 0 {
+		return ""
+	}
+
+	hash := sha256.New()
+	for _, sum := range sums {
+		_, _ = io.WriteString(hash, sum)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// FileSha256 hex encoded sha256 of file content
+func FileSha256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err = io.Copy(hash, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func matchSegments(patterns, names []string) bool {
+	if len(patterns) == 0 {
+		return len(names) == 0
+	}
+
+	if patterns[0] == "**" {
+		for i := 0; i <= len(names); i++ {
+			if matchSegments(patterns[1:], names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(names) == 0 {
+		return false
+	}
+
+	if ok, err := path.Match(patterns[0], names[0]); err != nil || !ok {
+		return false
+	}
+
+	return matchSegments(patterns[1:], names[1:])
+}
@@ -0,0 +1,56 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldMatchPathWithGlob(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(MatchPath("**/go.sum", "go.sum"))
+	assert.True(MatchPath("**/go.sum", "a/b/go.sum"))
+	assert.True(MatchPath("a/**/*.json", "a/package.json"))
+	assert.True(MatchPath("a/**/*.json", "a/b/c/package.json"))
+	assert.True(MatchPath("go.?um", "go.sum"))
+
+	assert.False(MatchPath("go.sum", "a/go.sum"))
+	assert.False(MatchPath("*/go.sum", "a/b/go.sum"))
+	assert.False(MatchPath("a/**/*.json", "b/package.json"))
+}
+
+func TestShouldHashFilesStably(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "test_hash_files_")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	assert.NoError(os.MkdirAll(filepath.Join(dir, "sub"), os.ModePerm))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "go.sum"), []byte("a"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sub", "go.sum"), []byte("b"), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sub", "main.go"), []byte("c"), 0644))
+
+	sum, err := HashFiles(dir, []string{"**/go.sum"})
+	assert.NoError(err)
+	assert.Len(sum, 64)
+
+	again, err := HashFiles(dir, []string{"sub/go.sum", "go.sum"})
+	assert.NoError(err)
+	assert.Equal(sum, again)
+
+	// changed on content
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "sub", "go.sum"), []byte("d"), 0644))
+	changed, err := HashFiles(dir, []string{"**/go.sum"})
+	assert.NoError(err)
+	assert.NotEqual(sum, changed)
+
+	// empty if nothing matched
+	none, err := HashFiles(dir, []string{"**/package-lock.json"})
+	assert.NoError(err)
+	assert.Empty(none)
+}